@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-boy/internal/coverage"
+	"go-boy/internal/disasm"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// go-boy disasm [flags] game.gb: disassembles the ROM, or some of its banks, to the standard output or a file.
+func disasmCommand(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	banks := flags.String("banks", "", "banks to disassemble, like 0, 1-3 or 2- (all of them by default)")
+	symFile := flags.String("sym", "", "symbol file (.sym or .map) with the labels, by default the one next to the game")
+	cdlFile := flags.String("cdl", "", "code/data log recorded with -cdl, to write the bytes only read as data as such")
+	outFile := flags.String("o", "", "write the disassembly to a file instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s disasm [flags] game.gb\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	rom, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	bankCount := (len(rom) + coverage.BankSize - 1) / coverage.BankSize
+	first, last, err := parseBankRange(*banks, bankCount)
+	if err != nil {
+		return err
+	}
+	var symbols *disasm.Symbols
+	if *symFile != "" {
+		symbols, err = disasm.LoadSymbols(*symFile)
+	} else {
+		symbols, err = disasm.FindSymbols(flags.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	var cdl []byte
+	if *cdlFile != "" {
+		if cdl, err = os.ReadFile(*cdlFile); err != nil {
+			return err
+		}
+		if len(cdl) != len(rom) {
+			return fmt.Errorf("%s is a code/data log for a ROM of %d bytes, this one has %d", *cdlFile, len(cdl), len(rom))
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *outFile != "" {
+		file, err := os.Create(*outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	for bank := first; bank <= last; bank++ {
+		if err = disasm.WriteBank(out, rom, bank, symbols, cdl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse a range of banks like "3", "1-3" or "2-". Empty means all of them.
+func parseBankRange(text string, count int) (int, int, error) {
+	first, last := 0, count-1
+	if text != "" {
+		bounds := strings.SplitN(text, "-", 2)
+		var err error
+		if first, err = strconv.Atoi(bounds[0]); err != nil {
+			return 0, 0, fmt.Errorf("invalid bank range %q", text)
+		}
+		last = first
+		if len(bounds) == 2 && bounds[1] == "" {
+			last = count - 1
+		} else if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, 0, fmt.Errorf("invalid bank range %q", text)
+			}
+		}
+	}
+	if first < 0 || last >= count || first > last {
+		return 0, 0, fmt.Errorf("the ROM has banks 0 to %d, %q is out of them", count-1, text)
+	}
+	return first, last, nil
+}
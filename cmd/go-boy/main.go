@@ -1,25 +1,96 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"flag"
 	"fmt"
+	"go-boy/internal/callstack"
+	"go-boy/internal/coverage"
+	"go-boy/internal/debugger"
+	"go-boy/internal/disasm"
 	game2 "go-boy/internal/game"
-	"go-boy/internal/gpu"
-	"go-boy/internal/memory"
-	"go-boy/internal/registers"
+	"go-boy/internal/gdb"
+	"go-boy/internal/link"
+	"go-boy/internal/movie"
+	"go-boy/internal/printer"
+	"go-boy/internal/profile"
+	"go-boy/internal/serial"
+	"go-boy/internal/sound"
+	"go-boy/internal/trace"
+	"hash/crc32"
 	"log"
 	"os"
 	"runtime"
-
-	"github.com/hajimehoshi/ebiten/v2"
+	"strings"
 )
 
 func init() {
 	runtime.LockOSThread()
 }
 
+// Flag that can be passed several times, keeping all the values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// A debugger attached to the game. With a window, the frames are run through it, and it runs in the background.
+type attachedDebugger interface {
+	Lock()
+	Unlock()
+	Frame() error
+	Run(windowed bool)
+}
+
 func main() {
+	// Subcommands that don't run the game.
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		if err := disasmCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	headless := flag.Bool("headless", false, "run without a window, for -frames frames or until the movie ends")
+	frames := flag.Int("frames", 0, "number of frames to run headless")
+	recordFile := flag.String("record", "", "record the input of every frame from power on into a movie file")
+	playFile := flag.String("play", "", "play back the input recorded in a movie file")
+	usePrinter := flag.Bool("printer", false, "plug a Game Boy Printer to the serial port, saving printouts as PNG next to the game")
+	serialOut := flag.String("serial-out", "", "write every byte sent through the serial port to a file, or \"stdout\"")
+	linkListen := flag.String("link-listen", "", "wait for another go-boy to connect a link cable at this address, like :8765")
+	linkDial := flag.String("link-dial", "", "connect a link cable to another go-boy listening at this address, like localhost:8765")
+	wavFile := flag.String("wav", "", "record the sound into a 16 bit stereo WAV file")
+	symFile := flag.String("sym", "", "symbol file (.sym or .map) with the names of the addresses, by default the one next to the game")
+	debug := flag.Bool("debug", false, "start paused in a command line debugger, type help in it to see its commands")
+	gdbAddress := flag.String("gdb", "", "start paused, waiting for GDB to connect at this address, like :2345")
+	loadState := flag.String("load-state", "", "start from a save state instead of from power on")
+	saveState := flag.String("save-state", "", "save the state of the machine into a file when the game is closed or the headless run ends")
+	traceFile := flag.String("trace", "", "write the registers before every instruction into a file, compressed if it ends with .gz")
+	traceStart := flag.String("trace-start", "", "start tracing once this is met, like frame=120 or pc=0150-01FF (or both, comma separated)")
+	traceStop := flag.String("trace-stop", "", "stop tracing once this is met, like frame=600 or pc=Main")
+	profileFile := flag.String("profile", "", "count the cycles spent in every function and address, and write them in pprof's format into a file")
+	profileReport := flag.String("profile-report", "", "count the cycles spent in every function and address, and write the top ones into a file, or \"stdout\"")
+	cdlFile := flag.String("cdl", "", "record which ROM bytes are executed and which are read as data into a code/data log, adding to it if it exists")
+	wavChannels := flag.Bool("wav-channels", false, "with -wav, also record each channel into a file of its own, like sound-ch1.wav")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] game.gb\n       %s disasm [flags] game.gb\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	// First of all, check that the user passed a file as game. Panic otherwise.
-	filename := os.Args[1]
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
 	file, err := os.Open(filename)
 	if err != nil {
 		panic(err)
@@ -54,22 +125,266 @@ func main() {
 	}
 
 	// Initialize a Game struct
-	game := &game2.Game{
-		R:     registers.GetInitializedRegisters(),
-		M:     memory.GetInitializedMemory(file),
-		GPU:   gpu.InitGPU(),
-		Debug: false,
-	}
+	game := game2.New(file)
+	game.Calls = callstack.New()
 
 	if err = file.Close(); err != nil {
 		panic(err)
 	}
 
-	// Set the window's size and name.
-	ebiten.SetWindowSize(640, 576)
-	ebiten.SetWindowTitle(string(titleB))
-	// Run the emulator's main loop.
-	if err = ebiten.RunGame(game); err != nil {
+	// Load the symbols, if there are any, to show them while debugging.
+	if *symFile != "" {
+		game.Symbols, err = disasm.LoadSymbols(*symFile)
+	} else {
+		game.Symbols, err = disasm.FindSymbols(filename)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *loadState != "" {
+		if err = game.LoadStateFile(*loadState); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Without a window there's no keyboard nor controllers, so the input can only come from a movie.
+	if !*headless {
+		if game.Input, err = liveInput(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var player *movie.Player
+	if *playFile != "" {
+		if player, err = movie.Load(*playFile, game.M.Cartridge, game); err != nil {
+			log.Fatal(err)
+		}
+		// Once the movie is over, the player takes over.
+		player.Then = game.Input
+		game.Input = player
+	}
+	var recorder *movie.Recorder
+	if *recordFile != "" {
+		if game.Input == nil {
+			log.Fatal("nothing to record: headless runs need a movie to play")
+		}
+		// After loading a state, or a movie that starts from one, the recording starts from where the game is now.
+		var state []byte
+		if *loadState != "" || player != nil && player.State() != nil {
+			var buffer bytes.Buffer
+			if err = game.SaveState(&buffer); err != nil {
+				log.Fatal(err)
+			}
+			state = buffer.Bytes()
+		}
+		if recorder, err = movie.Record(*recordFile, game.Input, game.M.Cartridge, state); err != nil {
+			log.Fatal(err)
+		}
+		game.Input = recorder
+		game.Recording = true
+	}
+
+	// Only one thing can be plugged to the serial port.
+	plugged := 0
+	for _, used := range []bool{*usePrinter, *serialOut != "", *linkListen != "", *linkDial != ""} {
+		if used {
+			plugged++
+		}
+	}
+	if plugged > 1 {
+		log.Fatal("only one of -printer, -serial-out, -link-listen and -link-dial can be used at a time")
+	}
+
+	// Plug the link cable, if any.
+	var cable *link.Cable
+	if *linkListen != "" {
+		log.Printf("waiting for the other Game Boy to connect at %s", *linkListen)
+		cable, err = link.Listen(*linkListen)
+	} else if *linkDial != "" {
+		cable, err = link.Dial(*linkDial)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cable != nil {
+		game.Serial.Device = cable
+		defer cable.Close()
+	} else if *usePrinter {
+		game.Serial.Device = printer.NewPrinter(filename)
+	} else if *serialOut == "stdout" {
+		game.Serial.Device = &serial.TextSink{W: os.Stdout}
+	} else if *serialOut != "" {
+		output, err := os.Create(*serialOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer output.Close()
+		game.Serial.Device = &serial.TextSink{W: output}
+	}
+
+	// Record the sound, if asked to.
+	var wav *sound.WAVRecorder
+	if *wavFile != "" {
+		if wav, err = sound.RecordWAV(*wavFile, game.APU.SampleRate, *wavChannels); err != nil {
+			log.Fatal(err)
+		}
+		game.APU.Output = wav
+	}
+
+	// Trace the instructions, if asked to. The conditions can use the symbols.
+	if *traceFile != "" {
+		if game.Trace, err = createTrace(*traceFile, *traceStart, *traceStop, game.Symbols); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The log covers the whole ROM file, even the banks that can't be switched in, to match its disassembly.
+	if *cdlFile != "" {
+		info, err := os.Stat(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if game.Coverage, err = coverage.Load(*cdlFile, int(info.Size()), game.M.ROMBank); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *profileFile != "" || *profileReport != "" {
+		game.Profile = profile.New()
+	}
+
+	var dbg attachedDebugger
+	if *debug && *gdbAddress != "" {
+		log.Fatal("only one of -debug and -gdb can be used at a time")
+	} else if *debug {
+		dbg = debugger.New(game, os.Stdin, os.Stdout)
+	} else if *gdbAddress != "" {
+		log.Printf("waiting for GDB to connect at %s", *gdbAddress)
+		if dbg, err = gdb.Listen(*gdbAddress, game); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *headless && dbg != nil {
+		// Headless, the debugger runs the game itself until it's quit, or GDB detaches.
+		dbg.Run(false)
+	} else if *headless {
+		err = runHeadless(game, *frames, player)
+	} else {
+		err = runWindow(game, dbg, string(titleB), filename)
+	}
+	if err == nil && *saveState != "" {
+		err = game.SaveStateFile(*saveState)
+	}
+	if err == nil && game.Coverage != nil {
+		err = game.Coverage.Save(*cdlFile)
+	}
+	if err == nil && game.Profile != nil {
+		err = writeProfile(game, *profileFile, *profileReport)
+	}
+	if recorder != nil {
+		if closeErr := recorder.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if wav != nil {
+		if closeErr := wav.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if game.Trace != nil {
+		if closeErr := game.Trace.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// Writes what the profiler counted, in pprof's format and as a report, as asked.
+func writeProfile(game *game2.Game, pprofFile, reportFile string) error {
+	if pprofFile != "" {
+		file, err := os.Create(pprofFile)
+		if err != nil {
+			return err
+		}
+		if err = game.Profile.WritePprof(file, game.Symbols); err != nil {
+			file.Close()
+			return err
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+	}
+	if reportFile == "stdout" {
+		return game.Profile.WriteReport(os.Stdout, game.Symbols, profileRows)
+	} else if reportFile != "" {
+		file, err := os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		if err = game.Profile.WriteReport(file, game.Symbols, profileRows); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	return nil
+}
+
+// Number of functions and addresses in the profile report.
+const profileRows = 30
+
+// Creates the trace file with its start and stop conditions, if they're given.
+func createTrace(filename, start, stop string, symbols *disasm.Symbols) (*trace.Writer, error) {
+	startCondition, stopCondition := trace.Always, (*trace.Condition)(nil)
+	var err error
+	if start != "" {
+		if startCondition, err = trace.ParseCondition(start, symbols); err != nil {
+			return nil, err
+		}
+	}
+	if stop != "" {
+		c, err := trace.ParseCondition(stop, symbols)
+		if err != nil {
+			return nil, err
+		}
+		stopCondition = &c
+	}
+	t, err := trace.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	t.Start, t.Stop = startCondition, stopCondition
+	return t, nil
+}
+
+// Runs the game without a window for the given number of frames or, if 0, until the movie is over.
+// At the end, it prints a checksum of the whole machine, so that two runs can be compared.
+func runHeadless(game *game2.Game, frames int, player *movie.Player) error {
+	if frames == 0 {
+		if player == nil {
+			return fmt.Errorf("headless runs need either -frames or -play")
+		}
+		frames = player.Frames()
+	}
+	for i := 0; i < frames; i++ {
+		if err := game.Update(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d frames, state checksum %08X\n", game.Frame, stateChecksum(game))
+	return nil
+}
+
+// CRC32 of the registers and all the memory.
+func stateChecksum(game *game2.Game) uint32 {
+	h := crc32.NewIEEE()
+	_ = binary.Write(h, binary.LittleEndian, game.R)
+	for _, part := range [][]byte{
+		game.M.IER, game.M.InternalRAM, game.M.UnusableIO2, game.M.IOPorts, game.M.UnusableIO1,
+		game.M.OAM, game.M.RAM, game.M.SRAM, game.M.VRAM,
+	} {
+		h.Write(part)
+	}
+	return h.Sum32()
+}
@@ -0,0 +1,21 @@
+//go:build headless
+// +build headless
+
+package main
+
+import (
+	"errors"
+	game2 "go-boy/internal/game"
+	"go-boy/internal/joypad"
+)
+
+// This build has no window, nor keyboard, nor controllers. It can only run with -headless.
+var errNoWindow = errors.New("built without window support, run it with -headless")
+
+func liveInput() (joypad.Source, error) {
+	return nil, errNoWindow
+}
+
+func runWindow(_ *game2.Game, _ attachedDebugger, _, _ string) error {
+	return errNoWindow
+}
@@ -0,0 +1,137 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-boy/internal/debugger"
+	"go-boy/internal/display"
+	game2 "go-boy/internal/game"
+	"go-boy/internal/gdb"
+	"go-boy/internal/input"
+	"go-boy/internal/joypad"
+	"go-boy/internal/rewind"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Holding it rewinds the game.
+const rewindKey = ebiten.KeyTab
+
+// Flags to change the key and controller bindings. They only make sense with a window.
+var (
+	keyBindings, buttonBindings, turboBindings, turboButtonBindings, macros stringList
+
+	configFile     = flag.String("config", "", "JSON file with the key and controller bindings")
+	turboRate      = flag.Int("turbo-rate", 0, "frames turbo buttons stay pressed and then released")
+	gamepads       = flag.String("gamepads", "", "comma separated IDs of the controllers that drive the joypad, \"all\" for every one")
+	stickThreshold = flag.Float64("stick", -1, "how far the left stick has to be pushed to press a direction (0 to 1, 0 disables it)")
+
+	volume  = flag.Float64("volume", 1, "sound volume, from 0 to 1")
+	muted   = flag.Bool("mute", false, "start with the sound muted (M toggles it)")
+	noSound = flag.Bool("no-sound", false, "don't play any sound, and run exactly one frame per screen refresh")
+
+	rewindMB       = flag.Int("rewind-mb", 32, "megabytes of memory used to rewind the game with Tab, 0 disables it")
+	rewindInterval = flag.Int("rewind-interval", 4, "frames between the snapshots used to rewind")
+)
+
+func init() {
+	flag.Var(&keyBindings, "bind", "bind keys to a button, like a=Z,Space (can be repeated)")
+	flag.Var(&buttonBindings, "bind-pad", "bind controller buttons to a button, like a=RightBottom (can be repeated)")
+	flag.Var(&turboBindings, "bind-turbo", "bind turbo keys to a button, like a=A (can be repeated)")
+	flag.Var(&turboButtonBindings, "bind-turbo-pad", "bind turbo controller buttons to a button, like a=RightLeft (can be repeated)")
+	flag.Var(&macros, "macro", "bind a macro to a key, like R=a+b+select+start:5 (can be repeated)")
+}
+
+// Returns the keyboard and controllers as input source, with the bindings in the config file and flags.
+func liveInput() (joypad.Source, error) {
+	var err error
+	// Load the bindings from the config file, if any, and apply the ones passed as flags on top of them.
+	bindings := input.DefaultBindings()
+	if *configFile != "" {
+		if bindings, err = input.LoadBindings(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range keyBindings {
+		if err = bindings.BindKeys(spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range buttonBindings {
+		if err = bindings.BindButtons(spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range turboBindings {
+		if err = bindings.BindTurboKeys(spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range turboButtonBindings {
+		if err = bindings.BindTurboButtons(spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range macros {
+		if err = bindings.BindMacro(spec); err != nil {
+			return nil, err
+		}
+	}
+	if *turboRate > 0 {
+		bindings.TurboRate = *turboRate
+	}
+	if *gamepads != "" {
+		if err = bindings.SetGamepads(*gamepads); err != nil {
+			return nil, err
+		}
+	}
+	if *stickThreshold >= 0 {
+		bindings.StickThreshold = *stickThreshold
+	}
+	if err = bindings.CheckHotkeys(display.Hotkeys(rewindKey)); err != nil {
+		return nil, err
+	}
+	return input.NewEbiten(bindings)
+}
+
+// Opens a window and runs the game in it until it's closed.
+// The save state slots are kept next to the game file.
+// With a debugger, its commands are read from the terminal, or GDB, while the game runs in the window.
+func runWindow(game *game2.Game, dbg attachedDebugger, title, romFilename string) error {
+	// Set the window's size and name.
+	ebiten.SetWindowSize(640, 576)
+	ebiten.SetWindowTitle(title)
+	window := &display.Window{
+		Game:        game,
+		RewindKey:   rewindKey,
+		StatePrefix: strings.TrimSuffix(romFilename, filepath.Ext(romFilename)),
+	}
+	// Rewinding would make the movie being recorded go out of sync.
+	if *rewindMB > 0 && !game.Recording {
+		game.Rewind = rewind.New(*rewindInterval, *rewindMB<<20)
+	}
+	if !*noSound {
+		if *volume < 0 || *volume > 1 {
+			return fmt.Errorf("volume must be between 0 and 1, got %v", *volume)
+		}
+		audio, err := display.NewAudio(game.APU, *volume, *muted)
+		if err != nil {
+			return err
+		}
+		window.Audio = audio
+	}
+	if dbg != nil {
+		window.Debugger = dbg
+		go dbg.Run(true)
+	}
+	// Run the emulator's main loop.
+	if err := ebiten.RunGame(window); err != debugger.ErrQuit && err != gdb.ErrKilled {
+		return err
+	}
+	return nil
+}
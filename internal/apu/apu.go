@@ -0,0 +1,306 @@
+package apu
+
+import (
+	"go-boy/internal/memory"
+	"math"
+)
+
+// Frequency of the Game Boy (cycles per second)
+const frequency = 4194304
+
+// The frame sequencer runs at 512Hz, so it steps every 8192 cycles.
+const cyclesPerFrameStep = frequency / 512
+
+// Bits always read as 1 in every sound register from 0xFF10 to 0xFF2F, since they can't be read back.
+var readMasks = [0x20]byte{
+	0x80, 0x3F, 0x00, 0xFF, 0xBF, // NR10 - NR14
+	0xFF, 0x3F, 0x00, 0xFF, 0xBF, // unused, NR21 - NR24
+	0x7F, 0xFF, 0x9F, 0xFF, 0xBF, // NR30 - NR34
+	0xFF, 0xFF, 0x00, 0x00, 0xBF, // unused, NR41 - NR44
+	0x00, 0x00, 0x70, // NR50 - NR52
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // unused
+}
+
+// Sample is the sound coming out of the Game Boy at one moment.
+type Sample struct {
+	// Mixed output of both speakers, from -1 to 1.
+	Left, Right float32
+	// Output of each channel's DAC before mixing, from -1 to 1, or 0 if the DAC is off.
+	Channels [4]float32
+}
+
+// Output receives the sound made by the APU, one sample at a time.
+type Output interface {
+	WriteSample(s Sample)
+}
+
+// APU is the audio processing unit. Its registers are mapped from 0xFF10 to 0xFF3F, and it's stepped with
+// the same cycles as the rest of the Game Boy, so that the sound is always in sync with the game.
+type APU struct {
+	Channel1 *Square
+	Channel2 *Square
+	Channel3 *Wave
+	Channel4 *Noise
+	// Where the samples go, SampleRate times per second. Without an output, no samples are made.
+	Output     Output
+	SampleRate int
+	// Channels left out of the mix, to listen to the rest on their own. They keep running, and their
+	// output is still in the samples' Channels.
+	Muted [4]bool
+
+	powered bool
+	// The frame sequencer clocks the length counters, the envelopes and the sweep:
+	// length in steps 0, 2, 4 and 6, sweep in steps 2 and 6, and envelopes in step 7.
+	sequencerCycles int
+	sequencerStep   int // Next step to run.
+	// Cycles since the last sample, scaled by SampleRate so that they're always whole numbers.
+	sampleCycles int
+	// Charge of the capacitors that remove the DC offset of each speaker,
+	// and how much of it they keep from one sample to the next at chargeRate samples per second.
+	capacitorLeft, capacitorRight float32
+	charge                        float32
+	chargeRate                    int
+}
+
+// InitAPU returns an APU with the state the sound registers have been initialized with, and plugs it to the memory.
+func InitAPU(m *memory.Memory) *APU {
+	a := &APU{
+		Channel1:   &Square{hasSweep: true},
+		Channel2:   &Square{},
+		Channel3:   &Wave{},
+		Channel4:   &Noise{},
+		SampleRate: 48000,
+		powered:    m.IOPorts[0x26]&0x80 != 0,
+	}
+	// Load the settings in the registers without triggering anything, so NRx4 are left out.
+	// Only channel 1 is playing after booting.
+	for address := uint16(0xFF10); address < 0xFF24; address++ {
+		if register := int(address-0xFF10) % 5; register != 4 {
+			a.writeChannel(address, m.IOPorts[address-0xFF00])
+		}
+	}
+	a.Channel1.Enabled = m.IOPorts[0x26]&0x01 != 0
+	m.Sound = a
+	return a
+}
+
+// Store handles a write to one of the sound registers.
+func (a *APU) Store(m *memory.Memory, address uint16, n byte) {
+	// Wave RAM.
+	if address >= 0xFF30 {
+		if offset, ok := a.Channel3.waveRAMAddress(address - 0xFF30); ok {
+			m.IOPorts[0x30+offset] = n
+		}
+		return
+	}
+	// NR52. Only the power bit can be written.
+	if address == 0xFF26 {
+		if n&0x80 == 0 && a.powered {
+			a.powerOff(m)
+		} else if n&0x80 != 0 && !a.powered {
+			// The frame sequencer starts over when the APU is turned on.
+			a.powered = true
+			a.sequencerStep = 0
+			a.sequencerCycles = 0
+		}
+		m.IOPorts[0x26] = n & 0x80
+		return
+	}
+	// While it's off, the APU ignores writes to everything else, but for the lengths in NRx1.
+	if !a.powered {
+		a.loadLength(address, n)
+		return
+	}
+	m.IOPorts[address-0xFF00] = n
+	a.writeChannel(address, n)
+}
+
+// Pass a write to the channel the register belongs to.
+func (a *APU) writeChannel(address uint16, n byte) {
+	switch {
+	case address < 0xFF15:
+		a.Channel1.write(int(address-0xFF10), n, a.sequencerStep)
+	case address < 0xFF1A:
+		a.Channel2.write(int(address-0xFF15), n, a.sequencerStep)
+	case address < 0xFF1F:
+		a.Channel3.write(int(address-0xFF1A), n, a.sequencerStep)
+	case address < 0xFF24:
+		a.Channel4.write(int(address-0xFF1F), n, a.sequencerStep)
+	}
+}
+
+// Turning the APU off clears all its registers and stops all the channels. Wave RAM is left as it is,
+// and so are the length counters: on the DMG they're powered on their own.
+func (a *APU) powerOff(m *memory.Memory) {
+	for address := uint16(0xFF10); address < 0xFF26; address++ {
+		m.IOPorts[address-0xFF00] = 0
+		if !isLengthRegister(address) {
+			a.writeChannel(address, 0)
+		}
+	}
+	a.Channel1.Duty = 0
+	a.Channel2.Duty = 0
+	a.Channel1.Enabled = false
+	a.Channel2.Enabled = false
+	a.Channel3.Enabled = false
+	a.Channel4.Enabled = false
+	a.powered = false
+}
+
+// NR11, NR21, NR31 and NR41 have the length of each channel.
+func isLengthRegister(address uint16) bool {
+	return address == 0xFF11 || address == 0xFF16 || address == 0xFF1B || address == 0xFF20
+}
+
+// Load the length of a channel from its NRx1, leaving the rest of the register alone.
+func (a *APU) loadLength(address uint16, n byte) {
+	switch address {
+	case 0xFF11:
+		a.Channel1.length.load(n&0x3F, 64)
+	case 0xFF16:
+		a.Channel2.length.load(n&0x3F, 64)
+	case 0xFF1B:
+		a.Channel3.length.load(n, 256)
+	case 0xFF20:
+		a.Channel4.length.load(n&0x3F, 64)
+	}
+}
+
+// Read returns the value of a sound register. Some bits can't be read back and are always 1,
+// and NR52 tells which channels are playing.
+func (a *APU) Read(m *memory.Memory, address uint16) byte {
+	if address >= 0xFF30 {
+		if offset, ok := a.Channel3.waveRAMAddress(address - 0xFF30); ok {
+			return m.IOPorts[0x30+offset]
+		}
+		return 0xFF
+	}
+	stored := m.IOPorts[address-0xFF00]
+	if address == 0xFF26 {
+		stored &= 0x80
+		for i, enabled := range []bool{a.Channel1.Enabled, a.Channel2.Enabled, a.Channel3.Enabled, a.Channel4.Enabled} {
+			if enabled {
+				stored |= 1 << i
+			}
+		}
+	}
+	return stored | readMasks[address-0xFF10]
+}
+
+// Step advances the channels and the frame sequencer some cycles, and makes the samples due in them.
+func (a *APU) Step(cycles int, m *memory.Memory) {
+	if a.powered {
+		a.Channel1.step(cycles)
+		a.Channel2.step(cycles)
+		a.Channel3.step(cycles, m.IOPorts[0x30:0x40])
+		a.Channel4.step(cycles)
+
+		a.sequencerCycles += cycles
+		for a.sequencerCycles >= cyclesPerFrameStep {
+			a.sequencerCycles -= cyclesPerFrameStep
+			a.stepSequencer(m)
+		}
+	}
+
+	if a.Output == nil || a.SampleRate <= 0 {
+		return
+	}
+	a.sampleCycles += cycles * a.SampleRate
+	for a.sampleCycles >= frequency {
+		a.sampleCycles -= frequency
+		a.Output.WriteSample(a.mix(m))
+	}
+}
+
+func (a *APU) stepSequencer(m *memory.Memory) {
+	step := a.sequencerStep
+	if step%2 == 0 {
+		a.Channel1.clockLength()
+		a.Channel2.clockLength()
+		a.Channel3.clockLength()
+		a.Channel4.clockLength()
+	}
+	if step == 2 || step == 6 {
+		if frequency, changed := a.Channel1.clockSweep(); changed {
+			// The sweep writes the new frequency back to NR13 and NR14.
+			m.IOPorts[0x13] = byte(frequency)
+			m.IOPorts[0x14] = m.IOPorts[0x14]&0xF8 | byte(frequency>>8)
+		}
+	}
+	if step == 7 {
+		a.Channel1.clockEnvelope()
+		a.Channel2.clockEnvelope()
+		a.Channel4.clockEnvelope()
+	}
+	a.sequencerStep = (step + 1) % 8
+}
+
+// Mix the output of the 4 channels into both speakers.
+// NR51 tells which channels go to each speaker (bits 4-7 left, 0-3 right), and NR50 the volume of each one
+// (bits 4-6 left, 0-2 right).
+func (a *APU) mix(m *memory.Memory) Sample {
+	var s Sample
+	if !a.powered {
+		return s
+	}
+	dacs := [4]bool{a.Channel1.dacEnabled, a.Channel2.dacEnabled, a.Channel3.dacEnabled, a.Channel4.dacEnabled}
+	outputs := [4]byte{a.Channel1.Output(), a.Channel2.Output(), a.Channel3.Output(), a.Channel4.Output()}
+	nr50 := m.IOPorts[0x24]
+	nr51 := m.IOPorts[0x25]
+	anyDAC := false
+	for i := range outputs {
+		if !dacs[i] {
+			continue
+		}
+		anyDAC = true
+		// The DACs turn 0 into the highest voltage and 15 into the lowest.
+		s.Channels[i] = 1 - float32(outputs[i])/7.5
+		if a.Muted[i] {
+			continue
+		}
+		if nr51&(0x10<<i) != 0 {
+			s.Left += s.Channels[i]
+		}
+		if nr51&(0x01<<i) != 0 {
+			s.Right += s.Channels[i]
+		}
+	}
+	s.Left *= float32(nr50>>4&0x07+1) / 8 / 4
+	s.Right *= float32(nr50&0x07+1) / 8 / 4
+	if a.chargeRate != a.SampleRate {
+		// The capacitors keep a factor of 0.999958 of their charge every cycle.
+		a.charge = float32(math.Pow(0.999958, float64(frequency)/float64(a.SampleRate)))
+		a.chargeRate = a.SampleRate
+	}
+	s.Left = a.highPass(&a.capacitorLeft, s.Left, anyDAC)
+	s.Right = a.highPass(&a.capacitorRight, s.Right, anyDAC)
+	return s
+}
+
+// The Game Boy has a capacitor in each speaker's output that slowly removes the DC offset of the DACs.
+func (a *APU) highPass(capacitor *float32, in float32, anyDAC bool) float32 {
+	if !anyDAC {
+		return 0
+	}
+	out := in - *capacitor
+	*capacitor = in - out*a.charge
+	return out
+}
+
+// ToggleMute mutes the given channel, from 0 to 3, or unmutes it if it was muted.
+func (a *APU) ToggleMute(channel int) {
+	a.Muted[channel] = !a.Muted[channel]
+}
+
+// Solo mutes every channel but the given one. If it was already the only one playing, all of them are unmuted.
+func (a *APU) Solo(channel int) {
+	soloed := true
+	for i, muted := range a.Muted {
+		if muted != (i != channel) {
+			soloed = false
+		}
+	}
+	for i := range a.Muted {
+		a.Muted[i] = !soloed && i != channel
+	}
+}
@@ -0,0 +1,85 @@
+package apu
+
+import (
+	"go-boy/internal/memory"
+	"testing"
+)
+
+// An APU with all its registers cleared, powered on.
+func newAPU() (*APU, *memory.Memory) {
+	m := &memory.Memory{IOPorts: make([]byte, 0x4C)}
+	a := InitAPU(m)
+	a.Store(m, 0xFF26, 0x80)
+	return a, m
+}
+
+func TestLengthsAfterPowerOff(t *testing.T) {
+	tests := []struct {
+		name string
+		// NRx1, the register that turns the DAC on, and NRx4.
+		nrx1, dac, nrx4 uint16
+		dacOn           byte
+		length          func(a *APU) int
+		// Length written before and while powered off, and the counters they make.
+		before, after byte
+		want1, want2  int
+	}{
+		{"square 1", 0xFF11, 0xFF12, 0xFF14, 0xF0, func(a *APU) int { return a.Channel1.length.counter }, 0xBE, 0x30, 2, 16},
+		{"square 2", 0xFF16, 0xFF17, 0xFF19, 0xF0, func(a *APU) int { return a.Channel2.length.counter }, 0x3F, 0x00, 1, 64},
+		{"wave", 0xFF1B, 0xFF1A, 0xFF1E, 0x80, func(a *APU) int { return a.Channel3.length.counter }, 0xF0, 0x80, 16, 128},
+		{"noise", 0xFF20, 0xFF21, 0xFF23, 0xF0, func(a *APU) int { return a.Channel4.length.counter }, 0x20, 0x3C, 32, 4},
+	}
+	for _, test := range tests {
+		a, m := newAPU()
+		a.Store(m, test.dac, test.dacOn)
+		a.Store(m, test.nrx1, test.before)
+		a.Store(m, test.nrx4, 0x80)
+		if got := test.length(a); got != test.want1 {
+			t.Errorf("%s: length %d after loading it, want %d", test.name, got, test.want1)
+		}
+		// Powering off keeps the length counter.
+		a.Store(m, 0xFF26, 0x00)
+		if got := test.length(a); got != test.want1 {
+			t.Errorf("%s: length %d after powering off, want %d", test.name, got, test.want1)
+		}
+		if a.Read(m, 0xFF26)&0x0F != 0 {
+			t.Errorf("%s: channels still playing after powering off", test.name)
+		}
+		// While it's off, only the length can be written.
+		a.Store(m, test.nrx1, test.after)
+		a.Store(m, test.dac, test.dacOn)
+		if got := test.length(a); got != test.want2 {
+			t.Errorf("%s: length %d after writing it while off, want %d", test.name, got, test.want2)
+		}
+		if m.IOPorts[test.dac-0xFF00] != 0 || m.IOPorts[test.nrx1-0xFF00] != 0 {
+			t.Errorf("%s: registers written while off", test.name)
+		}
+		if a.Channel1.Duty != 0 || a.Channel2.Duty != 0 {
+			t.Errorf("%s: the duty was written while off", test.name)
+		}
+		// Once on again, triggering the channel plays the length written while off.
+		a.Store(m, 0xFF26, 0x80)
+		a.Store(m, test.dac, test.dacOn)
+		a.Store(m, test.nrx4, 0xC0)
+		if got := test.length(a); got != test.want2 {
+			t.Errorf("%s: length %d after triggering it, want %d", test.name, got, test.want2)
+		}
+	}
+}
+
+func TestRegistersClearedByPowerOff(t *testing.T) {
+	a, m := newAPU()
+	for address := uint16(0xFF10); address < 0xFF26; address++ {
+		a.Store(m, address, 0xFF&^0x80)
+	}
+	a.Store(m, 0xFF30, 0x12)
+	a.Store(m, 0xFF26, 0x00)
+	for address := uint16(0xFF10); address < 0xFF26; address++ {
+		if got, want := a.Read(m, address), readMasks[address-0xFF10]; got != want {
+			t.Errorf("%04X: read %02X after powering off, want %02X", address, got, want)
+		}
+	}
+	if got := a.Read(m, 0xFF30); got != 0x12 {
+		t.Errorf("wave RAM: read %02X after powering off, want 12", got)
+	}
+}
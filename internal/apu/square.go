@@ -0,0 +1,259 @@
+package apu
+
+// The 4 duty cycles of the square channels, as the 8 steps of their waveform.
+var dutyWaveforms = [4][8]byte{
+	{0, 0, 0, 0, 0, 0, 0, 1}, // 12.5%
+	{1, 0, 0, 0, 0, 0, 0, 1}, // 25%
+	{1, 0, 0, 0, 0, 1, 1, 1}, // 50%
+	{0, 1, 1, 1, 1, 1, 1, 0}, // 75%
+}
+
+// Square is one of the two square wave channels. Channel 1 also has a frequency sweep, channel 2 doesn't.
+// Their registers are NRx0 (sweep, channel 1 only), NRx1 (duty and length), NRx2 (envelope),
+// NRx3 (frequency LSB) and NRx4 (trigger, length enable and frequency MSB).
+type Square struct {
+	hasSweep bool
+
+	Enabled    bool
+	dacEnabled bool
+	Duty       byte
+	dutyStep   int
+	Frequency  uint16
+	timer      int
+	length     length
+	envelope   envelope
+
+	// Frequency sweep, channel 1 only.
+	sweepPeriod  byte
+	sweepNegate  bool
+	sweepShift   byte
+	sweepTimer   byte
+	sweepEnabled bool
+	sweepShadow  uint16
+	sweepNegated bool // A sweep calculation has been done in negate mode since the last trigger.
+}
+
+// Write handles a write to one of the channel's registers, from 0 (NRx0) to 4 (NRx4).
+// frameStep is the next step of the frame sequencer.
+func (s *Square) write(register int, n byte, frameStep int) {
+	switch register {
+	case 0:
+		s.sweepPeriod = n >> 4 & 0x07
+		s.sweepShift = n & 0x07
+		negate := n&0x08 != 0
+		// Quirk: leaving negate mode after a calculation has been done with it disables the channel.
+		if s.sweepNegate && !negate && s.sweepNegated {
+			s.Enabled = false
+		}
+		s.sweepNegate = negate
+	case 1:
+		s.Duty = n >> 6
+		s.length.load(n&0x3F, 64)
+	case 2:
+		s.envelope.write(n)
+		s.dacEnabled = n&0xF8 != 0
+		if !s.dacEnabled {
+			s.Enabled = false
+		}
+	case 3:
+		s.Frequency = s.Frequency&0x0700 | uint16(n)
+	case 4:
+		s.Frequency = s.Frequency&0x00FF | uint16(n&0x07)<<8
+		if s.length.setEnabled(n&0x40 != 0, frameStep) {
+			s.Enabled = false
+		}
+		if n&0x80 != 0 {
+			s.trigger(frameStep)
+		}
+	}
+}
+
+// Restart the channel.
+func (s *Square) trigger(frameStep int) {
+	s.Enabled = s.dacEnabled
+	s.length.trigger(64, frameStep)
+	s.timer = s.period()
+	s.envelope.trigger()
+
+	if s.hasSweep {
+		s.sweepShadow = s.Frequency
+		s.sweepTimer = s.sweepPeriod
+		if s.sweepTimer == 0 {
+			s.sweepTimer = 8
+		}
+		s.sweepEnabled = s.sweepPeriod != 0 || s.sweepShift != 0
+		s.sweepNegated = false
+		// With a shift, the overflow check is done right away.
+		if s.sweepShift != 0 {
+			s.sweepCalculation()
+		}
+	}
+}
+
+// Cycles between steps of the waveform.
+func (s *Square) period() int {
+	return (2048 - int(s.Frequency)) * 4
+}
+
+// Advance the waveform some cycles.
+func (s *Square) step(cycles int) {
+	s.timer -= cycles
+	for s.timer <= 0 {
+		s.timer += s.period()
+		s.dutyStep = (s.dutyStep + 1) % 8
+	}
+}
+
+// Output returns the current volume of the channel, from 0 to 15.
+func (s *Square) Output() byte {
+	if !s.Enabled {
+		return 0
+	}
+	return dutyWaveforms[s.Duty][s.dutyStep] * s.envelope.volume
+}
+
+// Hz returns the frequency of the tone being played. Each period of the waveform takes 8 steps.
+func (s *Square) Hz() float64 {
+	return frequency / float64(s.period()*8)
+}
+
+// Volume returns the current volume of the envelope, from 0 to 15.
+func (s *Square) Volume() byte {
+	return s.envelope.volume
+}
+
+func (s *Square) clockLength() {
+	if s.length.clock() {
+		s.Enabled = false
+	}
+}
+
+func (s *Square) clockEnvelope() {
+	s.envelope.clock()
+}
+
+// Clock the frequency sweep. It returns the new frequency when it has changed, so that it's written back to NR13 and NR14.
+func (s *Square) clockSweep() (uint16, bool) {
+	if s.sweepTimer > 0 {
+		s.sweepTimer--
+	}
+	if s.sweepTimer != 0 {
+		return 0, false
+	}
+	s.sweepTimer = s.sweepPeriod
+	if s.sweepTimer == 0 {
+		s.sweepTimer = 8
+	}
+	if !s.sweepEnabled || s.sweepPeriod == 0 {
+		return 0, false
+	}
+	frequency := s.sweepCalculation()
+	if frequency > 2047 || s.sweepShift == 0 {
+		return 0, false
+	}
+	s.sweepShadow = frequency
+	s.Frequency = frequency
+	// The new frequency goes through the overflow check again, but isn't used.
+	s.sweepCalculation()
+	return frequency, true
+}
+
+// Calculate the next frequency of the sweep, disabling the channel if it overflows.
+func (s *Square) sweepCalculation() uint16 {
+	delta := s.sweepShadow >> s.sweepShift
+	var frequency uint16
+	if s.sweepNegate {
+		frequency = s.sweepShadow - delta
+		s.sweepNegated = true
+	} else {
+		frequency = s.sweepShadow + delta
+	}
+	if frequency > 2047 {
+		s.Enabled = false
+	}
+	return frequency
+}
+
+// Length counter. When enabled, it silences the channel once it reaches 0.
+type length struct {
+	enabled bool
+	counter int
+}
+
+// Load a new length. The register holds how much has already been played of the full one.
+func (l *length) load(n byte, full int) {
+	l.counter = full - int(n)
+}
+
+// Enable or disable the counter. It returns whether the channel has to be disabled.
+// Quirk: enabling it during the first half of a length period clocks it once more.
+func (l *length) setEnabled(enabled bool, frameStep int) bool {
+	extraClock := !l.enabled && enabled && frameStep%2 == 1
+	l.enabled = enabled
+	if extraClock && l.counter > 0 {
+		l.counter--
+		return l.counter == 0
+	}
+	return false
+}
+
+// When a channel is triggered with its length over, it's reset to the full length.
+func (l *length) trigger(full int, frameStep int) {
+	if l.counter == 0 {
+		l.counter = full
+		// Quirk: same extra clock as when enabling it.
+		if l.enabled && frameStep%2 == 1 {
+			l.counter--
+		}
+	}
+}
+
+// Clock the counter. It returns whether it has just reached 0.
+func (l *length) clock() bool {
+	if !l.enabled || l.counter == 0 {
+		return false
+	}
+	l.counter--
+	return l.counter == 0
+}
+
+// Volume envelope. Every period, the volume goes one step up or down, until it reaches 0 or 15.
+type envelope struct {
+	initial byte
+	up      bool
+	period  byte
+	timer   byte
+	volume  byte
+}
+
+func (e *envelope) write(n byte) {
+	e.initial = n >> 4
+	e.up = n&0x08 != 0
+	e.period = n & 0x07
+}
+
+func (e *envelope) trigger() {
+	e.volume = e.initial
+	e.timer = e.period
+	if e.timer == 0 {
+		e.timer = 8
+	}
+}
+
+func (e *envelope) clock() {
+	if e.period == 0 {
+		return
+	}
+	if e.timer > 0 {
+		e.timer--
+	}
+	if e.timer != 0 {
+		return
+	}
+	e.timer = e.period
+	if e.up && e.volume < 15 {
+		e.volume++
+	} else if !e.up && e.volume > 0 {
+		e.volume--
+	}
+}
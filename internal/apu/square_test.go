@@ -0,0 +1,132 @@
+package apu
+
+import "testing"
+
+// A square channel triggered with the given registers, NRx0 to NRx4, the last one with its trigger bit set.
+func triggered(registers [5]byte) *Square {
+	s := &Square{hasSweep: true}
+	for i, n := range registers[:4] {
+		s.write(i, n, 0)
+	}
+	s.write(4, registers[4]|0x80, 0)
+	return s
+}
+
+func TestDuty(t *testing.T) {
+	tests := []struct {
+		duty byte
+		high int
+	}{
+		{0, 1}, {1, 2}, {2, 4}, {3, 6},
+	}
+	for _, test := range tests {
+		s := triggered([5]byte{0x00, test.duty << 6, 0xF0, 0xFF, 0x07})
+		high := 0
+		for step := 0; step < 8; step++ {
+			if out := s.Output(); out == 15 {
+				high++
+			} else if out != 0 {
+				t.Errorf("duty %d: output %d, want 0 or 15", test.duty, out)
+			}
+			s.step(s.period())
+		}
+		if high != test.high {
+			t.Errorf("duty %d: high %d steps out of 8, want %d", test.duty, high, test.high)
+		}
+	}
+}
+
+func TestSquareLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		nrx1    byte
+		enabled bool
+		clocks  int
+		playing bool
+	}{
+		{"last step", 0x3F, true, 1, false},
+		{"not over yet", 0x3E, true, 1, true},
+		{"over", 0x3E, true, 2, false},
+		{"disabled", 0x3F, false, 10, true},
+		{"full length", 0x00, true, 63, true},
+	}
+	for _, test := range tests {
+		nrx4 := byte(0x00)
+		if test.enabled {
+			nrx4 = 0x40
+		}
+		s := triggered([5]byte{0x00, test.nrx1, 0xF0, 0x00, nrx4})
+		for i := 0; i < test.clocks; i++ {
+			s.clockLength()
+		}
+		if s.Enabled != test.playing {
+			t.Errorf("%s: playing %v, want %v", test.name, s.Enabled, test.playing)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	tests := []struct {
+		name   string
+		nrx2   byte
+		clocks int
+		volume byte
+	}{
+		{"down", 0xF1, 3, 12},
+		{"up", 0x09, 3, 3},
+		{"slower", 0xF2, 3, 14},
+		{"stops at 0", 0x21, 5, 0},
+		{"stops at 15", 0xE9, 5, 15},
+		{"no period", 0xA0, 10, 10},
+	}
+	for _, test := range tests {
+		s := triggered([5]byte{0x00, 0x00, test.nrx2, 0x00, 0x00})
+		for i := 0; i < test.clocks; i++ {
+			s.clockEnvelope()
+		}
+		if v := s.Volume(); v != test.volume {
+			t.Errorf("%s: volume %d, want %d", test.name, v, test.volume)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	tests := []struct {
+		name      string
+		nr10      byte
+		frequency uint16
+		clocks    int
+		want      uint16
+		playing   bool
+	}{
+		{"up", 0x11, 0x100, 1, 0x180, true},
+		{"down", 0x19, 0x100, 1, 0x080, true},
+		{"twice", 0x12, 0x100, 2, 0x190, true},
+		{"slower", 0x21, 0x100, 1, 0x100, true},
+		{"overflow on trigger", 0x01, 0x7FF, 0, 0x7FF, false},
+		{"overflow", 0x11, 0x500, 1, 0x780, false},
+		{"no shift", 0x10, 0x100, 3, 0x100, true},
+	}
+	for _, test := range tests {
+		s := triggered([5]byte{test.nr10, 0x00, 0xF0, byte(test.frequency), byte(test.frequency >> 8)})
+		for i := 0; i < test.clocks; i++ {
+			s.clockSweep()
+		}
+		if s.Frequency != test.want || s.Enabled != test.playing {
+			t.Errorf("%s: frequency %03X, playing %v, want %03X, %v",
+				test.name, s.Frequency, s.Enabled, test.want, test.playing)
+		}
+	}
+}
+
+func TestDACOff(t *testing.T) {
+	s := triggered([5]byte{0x00, 0x00, 0xF0, 0x00, 0x00})
+	s.write(2, 0x07, 0)
+	if s.Enabled {
+		t.Error("still playing with the DAC off")
+	}
+	s.write(4, 0x80, 0)
+	if s.Enabled {
+		t.Error("triggered with the DAC off")
+	}
+}
@@ -0,0 +1,58 @@
+package apu
+
+import "go-boy/internal/state"
+
+// SaveState writes everything the APU needs to carry on playing exactly the same after LoadState.
+// The registers and wave RAM are in memory, so they're saved with it.
+func (a *APU) SaveState(e *state.Encoder) {
+	for _, c := range []*Square{a.Channel1, a.Channel2} {
+		e.Write(c.Enabled, c.dacEnabled, c.Duty, c.dutyStep, c.Frequency, c.timer)
+		c.length.saveState(e)
+		c.envelope.saveState(e)
+		e.Write(c.sweepPeriod, c.sweepNegate, c.sweepShift, c.sweepTimer, c.sweepEnabled, c.sweepShadow, c.sweepNegated)
+	}
+	w := a.Channel3
+	e.Write(w.Enabled, w.dacEnabled, w.VolumeCode, w.Frequency, w.timer, w.position, w.sample, w.sinceRead)
+	w.length.saveState(e)
+	n := a.Channel4
+	e.Write(n.Enabled, n.dacEnabled, n.ClockShift, n.ShortMode, n.divisor, n.timer, n.lfsr)
+	n.length.saveState(e)
+	n.envelope.saveState(e)
+	e.Write(a.powered, a.sequencerCycles, a.sequencerStep, a.sampleCycles,
+		a.capacitorLeft, a.capacitorRight, a.charge, a.chargeRate)
+}
+
+// LoadState reads back what SaveState wrote.
+func (a *APU) LoadState(d *state.Decoder) {
+	for _, c := range []*Square{a.Channel1, a.Channel2} {
+		d.Read(&c.Enabled, &c.dacEnabled, &c.Duty, &c.dutyStep, &c.Frequency, &c.timer)
+		c.length.loadState(d)
+		c.envelope.loadState(d)
+		d.Read(&c.sweepPeriod, &c.sweepNegate, &c.sweepShift, &c.sweepTimer, &c.sweepEnabled, &c.sweepShadow, &c.sweepNegated)
+	}
+	w := a.Channel3
+	d.Read(&w.Enabled, &w.dacEnabled, &w.VolumeCode, &w.Frequency, &w.timer, &w.position, &w.sample, &w.sinceRead)
+	w.length.loadState(d)
+	n := a.Channel4
+	d.Read(&n.Enabled, &n.dacEnabled, &n.ClockShift, &n.ShortMode, &n.divisor, &n.timer, &n.lfsr)
+	n.length.loadState(d)
+	n.envelope.loadState(d)
+	d.Read(&a.powered, &a.sequencerCycles, &a.sequencerStep, &a.sampleCycles,
+		&a.capacitorLeft, &a.capacitorRight, &a.charge, &a.chargeRate)
+}
+
+func (l *length) saveState(e *state.Encoder) {
+	e.Write(l.enabled, l.counter)
+}
+
+func (l *length) loadState(d *state.Decoder) {
+	d.Read(&l.enabled, &l.counter)
+}
+
+func (env *envelope) saveState(e *state.Encoder) {
+	e.Write(env.initial, env.up, env.period, env.timer, env.volume)
+}
+
+func (env *envelope) loadState(d *state.Decoder) {
+	d.Read(&env.initial, &env.up, &env.period, &env.timer, &env.volume)
+}
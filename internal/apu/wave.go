@@ -0,0 +1,205 @@
+package apu
+
+// Wave is channel 3. It plays the 32 4-bit samples in wave RAM (0xFF30 - 0xFF3F), high nibble first.
+// Its registers are NR30 (DAC power), NR31 (length), NR32 (volume), NR33 (frequency LSB)
+// and NR34 (trigger, length enable and frequency MSB).
+type Wave struct {
+	Enabled    bool
+	dacEnabled bool
+	// Volume code: 0 mutes the channel, 1 plays the samples as they are, 2 at half, and 3 at a quarter.
+	VolumeCode byte
+	Frequency  uint16
+	timer      int
+	position   int  // Sample being played, from 0 to 31.
+	sample     byte // Last sample read from wave RAM.
+	sinceRead  int  // Cycles since the channel last read wave RAM.
+	length     length
+}
+
+func (w *Wave) write(register int, n byte, frameStep int) {
+	switch register {
+	case 0:
+		w.dacEnabled = n&0x80 != 0
+		if !w.dacEnabled {
+			w.Enabled = false
+		}
+	case 1:
+		w.length.load(n, 256)
+	case 2:
+		w.VolumeCode = n >> 5 & 0x03
+	case 3:
+		w.Frequency = w.Frequency&0x0700 | uint16(n)
+	case 4:
+		w.Frequency = w.Frequency&0x00FF | uint16(n&0x07)<<8
+		if w.length.setEnabled(n&0x40 != 0, frameStep) {
+			w.Enabled = false
+		}
+		if n&0x80 != 0 {
+			w.trigger(frameStep)
+		}
+	}
+}
+
+func (w *Wave) trigger(frameStep int) {
+	w.Enabled = w.dacEnabled
+	w.length.trigger(256, frameStep)
+	w.timer = w.period()
+	w.position = 0
+}
+
+// Cycles between samples.
+func (w *Wave) period() int {
+	return (2048 - int(w.Frequency)) * 2
+}
+
+// Hz returns the frequency of the tone being played. Each period of the waveform takes the 32 samples in wave RAM.
+func (w *Wave) Hz() float64 {
+	return frequency / float64(w.period()*32)
+}
+
+// Advance the channel some cycles, reading the next samples from wave RAM.
+func (w *Wave) step(cycles int, waveRAM []byte) {
+	w.sinceRead += cycles
+	if !w.Enabled {
+		return
+	}
+	w.timer -= cycles
+	for w.timer <= 0 {
+		w.timer += w.period()
+		w.position = (w.position + 1) % 32
+		w.sample = waveRAM[w.position/2]
+		if w.position%2 == 0 {
+			w.sample >>= 4
+		}
+		w.sample &= 0x0F
+		w.sinceRead = -w.timer
+	}
+}
+
+// While the channel plays, the CPU can only access wave RAM right when the channel reads it, and then it accesses
+// the byte the channel is reading, whatever the address. waveRAMAddress returns that byte's offset,
+// or false if wave RAM can't be accessed right now.
+func (w *Wave) waveRAMAddress(offset uint16) (uint16, bool) {
+	if !w.Enabled {
+		return offset, true
+	}
+	if w.sinceRead < 4 {
+		return uint16(w.position / 2), true
+	}
+	return 0, false
+}
+
+// Output returns the current volume of the channel, from 0 to 15.
+func (w *Wave) Output() byte {
+	if !w.Enabled || w.VolumeCode == 0 {
+		return 0
+	}
+	return w.sample >> (w.VolumeCode - 1)
+}
+
+func (w *Wave) clockLength() {
+	if w.length.clock() {
+		w.Enabled = false
+	}
+}
+
+// The divisors of the noise channel's clock, by the code in NR43.
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// Noise is channel 4. It outputs the lowest bit of a linear feedback shift register (LFSR), which can be 15 or 7 bits long.
+// Its registers are NR41 (length), NR42 (envelope), NR43 (clock shift, width and divisor) and NR44 (trigger and length enable).
+type Noise struct {
+	Enabled    bool
+	dacEnabled bool
+	ClockShift byte
+	// The LFSR is 7 bits long instead of 15, which sounds more like a tone than noise.
+	ShortMode bool
+	divisor   byte
+	timer     int
+	lfsr      uint16
+	length    length
+	envelope  envelope
+}
+
+func (c *Noise) write(register int, n byte, frameStep int) {
+	switch register {
+	case 1:
+		c.length.load(n&0x3F, 64)
+	case 2:
+		c.envelope.write(n)
+		c.dacEnabled = n&0xF8 != 0
+		if !c.dacEnabled {
+			c.Enabled = false
+		}
+	case 3:
+		c.ClockShift = n >> 4
+		c.ShortMode = n&0x08 != 0
+		c.divisor = n & 0x07
+	case 4:
+		if c.length.setEnabled(n&0x40 != 0, frameStep) {
+			c.Enabled = false
+		}
+		if n&0x80 != 0 {
+			c.trigger(frameStep)
+		}
+	}
+}
+
+func (c *Noise) trigger(frameStep int) {
+	c.Enabled = c.dacEnabled
+	c.length.trigger(64, frameStep)
+	c.timer = c.period()
+	c.envelope.trigger()
+	c.lfsr = 0x7FFF
+}
+
+// Cycles between shifts of the LFSR.
+func (c *Noise) period() int {
+	return noiseDivisors[c.divisor] << c.ClockShift
+}
+
+// Hz returns how many times per second the LFSR is shifted.
+func (c *Noise) Hz() float64 {
+	return frequency / float64(c.period())
+}
+
+// Advance the LFSR some cycles.
+func (c *Noise) step(cycles int) {
+	c.timer -= cycles
+	for c.timer <= 0 {
+		c.timer += c.period()
+		// With a clock shift of 14 or 15, the LFSR isn't clocked at all.
+		if c.ClockShift >= 14 {
+			continue
+		}
+		// XOR the two lowest bits, shift right and put the result in bit 14, and in bit 6 too in short mode.
+		feedback := (c.lfsr ^ c.lfsr>>1) & 0x01
+		c.lfsr = c.lfsr>>1 | feedback<<14
+		if c.ShortMode {
+			c.lfsr = c.lfsr&^0x40 | feedback<<6
+		}
+	}
+}
+
+// Output returns the current volume of the channel, from 0 to 15.
+func (c *Noise) Output() byte {
+	if !c.Enabled || c.lfsr&0x01 != 0 {
+		return 0
+	}
+	return c.envelope.volume
+}
+
+// Volume returns the current volume of the envelope, from 0 to 15.
+func (c *Noise) Volume() byte {
+	return c.envelope.volume
+}
+
+func (c *Noise) clockLength() {
+	if c.length.clock() {
+		c.Enabled = false
+	}
+}
+
+func (c *Noise) clockEnvelope() {
+	c.envelope.clock()
+}
@@ -0,0 +1,172 @@
+package apu
+
+import "testing"
+
+func TestWaveSamples(t *testing.T) {
+	waveRAM := []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC, 0xDE, 0xF0, 0, 0, 0, 0, 0, 0, 0, 0xFA}
+	tests := []struct {
+		volumeCode byte
+		// Outputs from the second sample on, after which it wraps around to the first one.
+		outputs []byte
+	}{
+		{0, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{1, []byte{2, 3, 4, 5, 6, 7, 8, 9}},
+		{2, []byte{1, 1, 2, 2, 3, 3, 4, 4}},
+		{3, []byte{0, 0, 1, 1, 1, 1, 2, 2}},
+	}
+	for _, test := range tests {
+		w := &Wave{}
+		w.write(0, 0x80, 0)
+		w.write(2, test.volumeCode<<5, 0)
+		w.write(3, 0xFF, 0)
+		w.write(4, 0x87, 0)
+		for i, want := range test.outputs {
+			w.step(w.period(), waveRAM)
+			if out := w.Output(); out != want {
+				t.Errorf("volume code %d, sample %d: got %d, want %d", test.volumeCode, i+1, out, want)
+			}
+		}
+		// The last sample, and then back to the first one.
+		volume := func(sample byte) byte {
+			if test.volumeCode == 0 {
+				return 0
+			}
+			return sample >> (test.volumeCode - 1)
+		}
+		w.step(w.period()*(32-len(test.outputs)-1), waveRAM)
+		if out := w.Output(); out != volume(0x0A) {
+			t.Errorf("volume code %d, last sample: got %d, want %d", test.volumeCode, out, volume(0x0A))
+		}
+		w.step(w.period(), waveRAM)
+		if out := w.Output(); out != volume(0x01) {
+			t.Errorf("volume code %d, first sample: got %d, want %d", test.volumeCode, out, volume(0x01))
+		}
+	}
+}
+
+func TestWaveDACOff(t *testing.T) {
+	w := &Wave{}
+	w.write(0, 0x80, 0)
+	w.write(4, 0x80, 0)
+	if !w.Enabled {
+		t.Fatal("not playing after the trigger")
+	}
+	w.write(0, 0x00, 0)
+	if w.Enabled || w.Output() != 0 {
+		t.Error("still playing with the DAC off")
+	}
+}
+
+// The LFSR goes through every one of its values before repeating them: 2^15 - 1 of them, or 2^7 - 1 in short mode.
+func TestNoisePeriod(t *testing.T) {
+	tests := []struct {
+		short  bool
+		mask   uint16
+		period int
+	}{
+		{false, 0x7FFF, 32767},
+		{true, 0x7F, 127},
+	}
+	for _, test := range tests {
+		c := &Noise{}
+		c.write(2, 0xF0, 0)
+		nr43 := byte(0x00)
+		if test.short {
+			nr43 = 0x08
+		}
+		c.write(3, nr43, 0)
+		c.write(4, 0x80, 0)
+		// Let the bits shifted in reach the lowest ones first.
+		for i := 0; i < 15; i++ {
+			c.step(c.period())
+		}
+		start := c.lfsr & test.mask
+		period := 0
+		for period < 40000 {
+			c.step(c.period())
+			period++
+			if c.lfsr&test.mask == start {
+				break
+			}
+		}
+		if period != test.period {
+			t.Errorf("short mode %v: repeats after %d shifts, want %d", test.short, period, test.period)
+		}
+	}
+}
+
+func TestNoiseClock(t *testing.T) {
+	tests := []struct {
+		nr43   byte
+		period int
+	}{
+		{0x00, 8},
+		{0x01, 16},
+		{0x07, 112},
+		{0x10, 16},
+		{0x35, 640},
+	}
+	for _, test := range tests {
+		c := &Noise{}
+		c.write(3, test.nr43, 0)
+		if period := c.period(); period != test.period {
+			t.Errorf("NR43 %02X: %d cycles between shifts, want %d", test.nr43, period, test.period)
+		}
+	}
+	// With a clock shift of 14 or 15, the LFSR stays as it is.
+	c := &Noise{}
+	c.write(2, 0xF0, 0)
+	c.write(3, 0xE0, 0)
+	c.write(4, 0x80, 0)
+	c.step(10 * c.period())
+	if c.lfsr != 0x7FFF {
+		t.Errorf("the LFSR changed to %04X with a clock shift of 14", c.lfsr)
+	}
+}
+
+func TestMix(t *testing.T) {
+	tests := []struct {
+		name        string
+		nr50, nr51  byte
+		muted       [4]bool
+		left, right float32
+	}{
+		{"left", 0x77, 0x20, [4]bool{}, 0.25, 0},
+		{"right", 0x77, 0x02, [4]bool{}, 0, 0.25},
+		{"both", 0x33, 0x22, [4]bool{}, 0.125, 0.125},
+		{"muted", 0x77, 0x22, [4]bool{false, true}, 0, 0},
+		{"another muted", 0x77, 0x22, [4]bool{true}, 0.25, 0.25},
+	}
+	for _, test := range tests {
+		a, m := newAPU()
+		a.Store(m, 0xFF24, test.nr50)
+		a.Store(m, 0xFF25, test.nr51)
+		// Channel 2's DAC is on but it isn't playing, so it outputs the highest voltage.
+		a.Store(m, 0xFF17, 0xF0)
+		a.Muted = test.muted
+		s := a.mix(m)
+		if s.Left != test.left || s.Right != test.right {
+			t.Errorf("%s: got %v, %v, want %v, %v", test.name, s.Left, s.Right, test.left, test.right)
+		}
+		if s.Channels[1] != 1 || s.Channels[0] != 0 {
+			t.Errorf("%s: got channels %v", test.name, s.Channels)
+		}
+	}
+}
+
+func TestSolo(t *testing.T) {
+	a := &APU{}
+	a.Solo(2)
+	if a.Muted != [4]bool{true, true, false, true} {
+		t.Errorf("got %v after soloing channel 3", a.Muted)
+	}
+	a.Solo(2)
+	if a.Muted != [4]bool{} {
+		t.Errorf("got %v after soloing channel 3 again", a.Muted)
+	}
+	a.ToggleMute(0)
+	a.Solo(0)
+	if a.Muted != [4]bool{false, true, true, true} {
+		t.Errorf("got %v after soloing a muted channel", a.Muted)
+	}
+}
@@ -0,0 +1,152 @@
+package callstack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Frame is a call, restart or interrupt that hasn't returned yet.
+type Frame struct {
+	// Address of the call or restart, or of the instruction that was interrupted.
+	Caller uint16
+	// Address called, or the interrupt vector.
+	Entry uint16
+	// Address pushed onto the stack, where the function should return to.
+	Return uint16
+	// SP right after the return address was pushed. The return that pops it has to find SP there.
+	SP        uint16
+	Interrupt bool
+}
+
+// Imbalance is a call, interrupt or return that didn't match the calls before it. For example, a function that pops
+// its return address by hand and then returns, or jumps back by itself, returns to the wrong place or leaves its frame
+// behind, to be overwritten by the next call. Returns used as jumps to addresses pushed by hand don't match any call either.
+type Imbalance struct {
+	// Address of the call, return or interrupted instruction.
+	PC uint16
+	// For returns, the address it returned to.
+	Return   uint16
+	IsReturn bool
+	// Frames thrown away because of it, from the outermost to the innermost.
+	Dropped []Frame
+}
+
+func (i Imbalance) String() string {
+	if len(i.Dropped) == 0 {
+		return fmt.Sprintf("return at %04X to %04X without a call", i.PC, i.Return)
+	}
+	last := i.Dropped[len(i.Dropped)-1]
+	if !i.IsReturn {
+		return fmt.Sprintf("call at %04X overwrote the return address of the call at %04X", i.PC, last.Caller)
+	}
+	text := fmt.Sprintf("return at %04X to %04X, expected %04X from the call at %04X", i.PC, i.Return, last.Return, last.Caller)
+	if len(i.Dropped) > 1 {
+		text += fmt.Sprintf(", returning from %d calls at once", len(i.Dropped))
+	}
+	return text
+}
+
+// How many of the last imbalances are kept.
+const maxImbalances = 64
+
+// Stack is a shadow call stack: it follows the calls, restarts and interrupts as they push return addresses,
+// and the returns that pop them, without looking at the Game Boy's stack, which code can do anything with.
+type Stack struct {
+	frames []Frame
+	// The last imbalances found, from the oldest, and how many there were in total.
+	Imbalances     []Imbalance
+	ImbalanceCount int
+}
+
+// New returns an empty call stack.
+func New() *Stack {
+	return &Stack{}
+}
+
+// Frames returns the calls that haven't returned yet, from the outermost to the innermost.
+func (s *Stack) Frames() []Frame {
+	return s.frames
+}
+
+// Depth returns how many calls haven't returned yet.
+func (s *Stack) Depth() int {
+	return len(s.frames)
+}
+
+// Reset forgets all the calls, for example after loading a state, when they're no longer known.
+func (s *Stack) Reset() {
+	s.frames = s.frames[:0]
+}
+
+// Call records a call or restart at caller to entry, which pushed ret leaving SP at sp.
+func (s *Stack) Call(caller, entry, ret, sp uint16) {
+	s.push(Frame{Caller: caller, Entry: entry, Return: ret, SP: sp})
+}
+
+// Interrupt records an interrupt of the instruction at pc, which jumped to vector leaving SP at sp.
+func (s *Stack) Interrupt(pc, vector, sp uint16) {
+	s.push(Frame{Caller: pc, Entry: vector, Return: pc, SP: sp, Interrupt: true})
+}
+
+func (s *Stack) push(f Frame) {
+	// The stack grows down, so frames at or below the new SP are gone: SP was moved above them without returning.
+	n := len(s.frames)
+	for n > 0 && s.frames[n-1].SP <= f.SP {
+		n--
+	}
+	if n < len(s.frames) {
+		s.imbalance(Imbalance{PC: f.Caller, Dropped: s.drop(n)})
+	}
+	s.frames = append(s.frames, f)
+}
+
+// Return records a return at pc to ret, which popped the return address at sp, the SP from before popping it.
+// It returns false if it didn't match the innermost call, which is recorded as an imbalance.
+func (s *Stack) Return(pc, ret, sp uint16) bool {
+	n := len(s.frames)
+	if n > 0 && s.frames[n-1].SP == sp && s.frames[n-1].Return == ret {
+		s.frames = s.frames[:n-1]
+		return true
+	}
+	imbalance := Imbalance{PC: pc, Return: ret, IsReturn: true}
+	// If it popped the return address of a call, that one and the ones inside it are over, wherever it returned to.
+	for i := n - 1; i >= 0; i-- {
+		if s.frames[i].SP == sp {
+			imbalance.Dropped = s.drop(i)
+			break
+		}
+	}
+	s.imbalance(imbalance)
+	return false
+}
+
+// Remove the frames from i on, returning a copy of them.
+func (s *Stack) drop(i int) []Frame {
+	dropped := append([]Frame(nil), s.frames[i:]...)
+	s.frames = s.frames[:i]
+	return dropped
+}
+
+func (s *Stack) imbalance(i Imbalance) {
+	if len(s.Imbalances) == maxImbalances {
+		s.Imbalances = s.Imbalances[1:]
+	}
+	s.Imbalances = append(s.Imbalances, i)
+	s.ImbalanceCount++
+}
+
+// Backtrace writes the calls from the innermost one, starting at pc, one per line. describe writes an address, for
+// example with the name of its function.
+func (s *Stack) Backtrace(pc uint16, describe func(address uint16) string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#0  %s\n", describe(pc))
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		f := s.frames[i]
+		if f.Interrupt {
+			fmt.Fprintf(&b, "#%d  %s  <interrupted, handler at %04X>\n", len(s.frames)-i, describe(f.Return), f.Entry)
+		} else {
+			fmt.Fprintf(&b, "#%d  %s\n", len(s.frames)-i, describe(f.Caller))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package callstack
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStack(t *testing.T) {
+	type event struct {
+		kind     string // "call", "interrupt" or "return".
+		pc, to   uint16 // Where it was, and where it went.
+		ret, sp  uint16
+		balanced bool // For returns, whether they should match.
+	}
+	tests := []struct {
+		name       string
+		events     []event
+		callers    []uint16 // Of the frames left, from the outermost.
+		imbalances []string
+	}{
+		{"nested", []event{
+			{kind: "call", pc: 0x0150, to: 0x0200, ret: 0x0153, sp: 0xDFFC},
+			{kind: "call", pc: 0x0210, to: 0x0300, ret: 0x0213, sp: 0xDFFA},
+			{kind: "return", pc: 0x0301, to: 0x0213, sp: 0xDFFA, balanced: true},
+		}, []uint16{0x0150}, nil},
+		{"interrupt", []event{
+			{kind: "call", pc: 0x0150, to: 0x0200, ret: 0x0153, sp: 0xDFFC},
+			{kind: "interrupt", pc: 0x0205, to: 0x0040, sp: 0xDFFA},
+			{kind: "return", pc: 0x0045, to: 0x0205, sp: 0xDFFA, balanced: true},
+			{kind: "return", pc: 0x0210, to: 0x0153, sp: 0xDFFC, balanced: true},
+		}, nil, nil},
+		{"return without a call", []event{
+			{kind: "return", pc: 0x0210, to: 0x1234, sp: 0xDFFC},
+		}, nil, []string{"return at 0210 to 1234 without a call"}},
+		{"popped by hand", []event{
+			{kind: "call", pc: 0x0150, to: 0x0200, ret: 0x0153, sp: 0xDFFC},
+			{kind: "call", pc: 0x0210, to: 0x0300, ret: 0x0213, sp: 0xDFFA},
+			// The function at 0300 pops its return address and returns to the caller of 0200.
+			{kind: "return", pc: 0x0301, to: 0x0153, sp: 0xDFFC},
+		}, nil, []string{"return at 0301 to 0153, expected 0213 from the call at 0210, returning from 2 calls at once"}},
+		{"wrong address", []event{
+			{kind: "call", pc: 0x0150, to: 0x0200, ret: 0x0153, sp: 0xDFFC},
+			{kind: "return", pc: 0x0201, to: 0x0400, sp: 0xDFFC},
+		}, nil, []string{"return at 0201 to 0400, expected 0153 from the call at 0150"}},
+		{"left behind", []event{
+			{kind: "call", pc: 0x0150, to: 0x0200, ret: 0x0153, sp: 0xDFFC},
+			// The function at 0200 jumps back without returning, and the next call overwrites its frame.
+			{kind: "call", pc: 0x0160, to: 0x0200, ret: 0x0163, sp: 0xDFFC},
+		}, []uint16{0x0160}, []string{"call at 0160 overwrote the return address of the call at 0150"}},
+	}
+	for _, test := range tests {
+		s := New()
+		for _, e := range test.events {
+			switch e.kind {
+			case "call":
+				s.Call(e.pc, e.to, e.ret, e.sp)
+			case "interrupt":
+				s.Interrupt(e.pc, e.to, e.sp)
+			case "return":
+				if balanced := s.Return(e.pc, e.to, e.sp); balanced != e.balanced {
+					t.Errorf("%s: the return at %04X matched: %t", test.name, e.pc, balanced)
+				}
+			}
+		}
+		var callers []uint16
+		for _, f := range s.Frames() {
+			callers = append(callers, f.Caller)
+		}
+		if fmt.Sprint(callers) != fmt.Sprint(test.callers) || s.Depth() != len(test.callers) {
+			t.Errorf("%s: got frames called at %X, want %X", test.name, callers, test.callers)
+		}
+		var imbalances []string
+		for _, i := range s.Imbalances {
+			imbalances = append(imbalances, i.String())
+		}
+		if fmt.Sprint(imbalances) != fmt.Sprint(test.imbalances) || s.ImbalanceCount != len(test.imbalances) {
+			t.Errorf("%s: got imbalances %q, want %q", test.name, imbalances, test.imbalances)
+		}
+	}
+}
+
+func TestImbalancesKept(t *testing.T) {
+	s := New()
+	for i := 0; i < maxImbalances+10; i++ {
+		s.Return(uint16(i), 0, 0xDFFE)
+	}
+	if s.ImbalanceCount != maxImbalances+10 || len(s.Imbalances) != maxImbalances {
+		t.Fatalf("got %d imbalances, %d kept", s.ImbalanceCount, len(s.Imbalances))
+	}
+	if first := s.Imbalances[0].PC; first != 10 {
+		t.Errorf("the oldest imbalance kept is at %04X, want 000A", first)
+	}
+}
+
+func TestBacktrace(t *testing.T) {
+	s := New()
+	s.Call(0x0150, 0x0200, 0x0153, 0xDFFC)
+	s.Interrupt(0x0205, 0x0040, 0xDFFA)
+	s.Call(0x0042, 0x0300, 0x0045, 0xDFF8)
+	want := "#0  0310\n" +
+		"#1  0042\n" +
+		"#2  0205  <interrupted, handler at 0040>\n" +
+		"#3  0150\n"
+	if got := s.Backtrace(0x0310, func(address uint16) string { return fmt.Sprintf("%04X", address) }); got != want {
+		t.Errorf("got backtrace\n%s\nwant\n%s", got, want)
+	}
+	s.Reset()
+	if got := s.Backtrace(0x0310, func(address uint16) string { return fmt.Sprintf("%04X", address) }); got != "#0  0310\n" {
+		t.Errorf("got backtrace %q after a reset", got)
+	}
+}
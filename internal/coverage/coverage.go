@@ -0,0 +1,104 @@
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Flags of every ROM byte in a code/data log. A byte can have several, like a byte both executed and read as data.
+const (
+	Opcode  = 0x01 // Executed as the first byte of an instruction.
+	Operand = 0x02 // Executed as one of the following bytes of an instruction.
+	Data    = 0x04 // Read by an instruction.
+)
+
+// Size of a ROM bank. Bank 0 is always at 0000 - 3FFF, and the rest are switched in at 4000 - 7FFF.
+const BankSize = 0x4000
+
+// Log is a code/data log (CDL) of the ROM: it records how each of its bytes has been used while the game runs.
+// Its file has a byte with the flags of each ROM byte, in the same order as in the ROM, so the bank of every byte
+// is its offset divided by 16KB. Bytes never used are 0.
+type Log struct {
+	Flags []byte
+	// ROM bank switched in at 4000 - 7FFF.
+	Bank func() int
+}
+
+// New returns an empty log for a ROM of the given size. bank tells the ROM bank switched in at any time.
+func New(romSize int, bank func() int) *Log {
+	return &Log{Flags: make([]byte, romSize), Bank: bank}
+}
+
+// Load reads the log in a file, to keep adding to it, or returns an empty one if there's no file yet.
+func Load(filename string, romSize int, bank func() int) (*Log, error) {
+	flags, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(romSize, bank), nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(flags) != romSize {
+		return nil, fmt.Errorf("%s is a code/data log for a ROM of %d bytes, this one has %d", filename, len(flags), romSize)
+	}
+	return &Log{Flags: flags, Bank: bank}, nil
+}
+
+// Save writes the log into a file.
+func (l *Log) Save(filename string) error {
+	return os.WriteFile(filename, l.Flags, 0644)
+}
+
+// Offset in the ROM of an address, as seen right now, or -1 if it's not in the ROM.
+func (l *Log) offset(address uint16) int {
+	offset := int(address)
+	if address >= 0x8000 {
+		return -1
+	} else if address >= BankSize {
+		offset = l.Bank()*BankSize + int(address-BankSize)
+	}
+	if offset >= len(l.Flags) {
+		return -1
+	}
+	return offset
+}
+
+func (l *Log) mark(address uint16, flag byte) {
+	if offset := l.offset(address); offset >= 0 {
+		l.Flags[offset] |= flag
+	}
+}
+
+// Instruction records that the instruction at address, of the given length, is executed.
+func (l *Log) Instruction(address uint16, length int) {
+	l.mark(address, Opcode)
+	for i := 1; i < length; i++ {
+		l.mark(address+uint16(i), Operand)
+	}
+}
+
+// Read implements memory.Watcher, marking the bytes read as data.
+func (l *Log) Read(address uint16, _ byte) {
+	l.mark(address, Data)
+}
+
+// Write implements memory.Watcher. Writes to the ROM only talk to its bank controller, so they don't mark anything.
+func (l *Log) Write(uint16, byte, byte) {}
+
+// Count returns how many bytes are code, executed either as opcodes or operands, how many are data, and how many
+// haven't been used at all. Bytes both executed and read count as both.
+func (l *Log) Count() (code, data, unused int) {
+	for _, f := range l.Flags {
+		if f&(Opcode|Operand) != 0 {
+			code++
+		}
+		if f&Data != 0 {
+			data++
+		}
+		if f == 0 {
+			unused++
+		}
+	}
+	return code, data, unused
+}
@@ -0,0 +1,80 @@
+package coverage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarks(t *testing.T) {
+	bank := 1
+	l := New(4*BankSize, func() int { return bank })
+	l.Instruction(0x0150, 3)
+	l.Read(0x0152, 0)
+	l.Instruction(0x4000, 2)
+	bank = 3
+	l.Instruction(0x4000, 1)
+	l.Read(0x7FFF, 0)
+	// Outside the ROM.
+	l.Read(0xC000, 0)
+	l.Instruction(0xFF80, 1)
+	bank = 4
+	l.Read(0x4000, 0)
+
+	tests := []struct {
+		offset int
+		flags  byte
+	}{
+		{0x0150, Opcode},
+		{0x0151, Operand},
+		{0x0152, Operand | Data},
+		{0x0153, 0},
+		{BankSize, Opcode},
+		{BankSize + 1, Operand},
+		{2 * BankSize, 0},
+		{3 * BankSize, Opcode},
+		{4*BankSize - 1, Data},
+	}
+	for _, test := range tests {
+		if flags := l.Flags[test.offset]; flags != test.flags {
+			t.Errorf("offset %05X: got flags %02X, want %02X", test.offset, flags, test.flags)
+		}
+	}
+	if code, data, unused := l.Count(); code != 6 || data != 2 || unused != 4*BankSize-7 {
+		t.Errorf("got %d bytes of code, %d of data and %d unused", code, data, unused)
+	}
+}
+
+func TestLoadAndSave(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "game.cdl")
+	bank := func() int { return 1 }
+	l, err := Load(filename, 2*BankSize, bank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, data, _ := l.Count(); code != 0 || data != 0 {
+		t.Fatal("a new log isn't empty")
+	}
+	l.Instruction(0x4010, 1)
+	if err = l.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another run adds to it.
+	l, err = Load(filename, 2*BankSize, bank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Read(0x0200, 0)
+	if l.Flags[BankSize+0x10] != Opcode || l.Flags[0x0200] != Data {
+		t.Error("the log wasn't loaded with what was saved")
+	}
+
+	if _, err = Load(filename, 4*BankSize, bank); err == nil || !strings.Contains(err.Error(), "for a ROM of 32768 bytes") {
+		t.Errorf("got %v for a log of another ROM", err)
+	}
+	// Only a missing file makes a new log.
+	if _, err = Load(t.TempDir(), 2*BankSize, bank); err == nil {
+		t.Error("loaded a directory as a log")
+	}
+}
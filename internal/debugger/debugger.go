@@ -0,0 +1,596 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-boy/internal/disasm"
+	"go-boy/internal/game"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrQuit is returned by Frame once the debugger has been quit, so that the window closes.
+var ErrQuit = errors.New("quit from the debugger")
+
+// Instructions executed before PC shown when listing the code around it.
+const historyLength = 4
+
+const help = `Commands (addresses in hex or as symbol names, values in hex, counts in decimal):
+  c, continue            run until a breakpoint is hit or another command is typed (Enter just pauses)
+  s, step [n]            execute n instructions (1 by default)
+  n, over                execute one instruction, running calls and restarts until they return
+  o, out                 run until the current function returns
+  b, break [addr]        set a breakpoint at addr, or list them all
+  d, delete [addr]       delete the breakpoint at addr, or all of them
+  watch [kind] addr[-end] pause when an instruction accesses addresses in a range, or list the watchpoints;
+                         kind is r for reads, w for writes (the default), rw for both, or c for writes that change them
+  unwatch [n]            delete watchpoint number n, as listed by watch, or all of them
+  r, regs                show the registers
+  set reg value          change a register: a, f, b, c, d, e, h, l, af, bc, de, hl, sp or pc
+  x addr [n]             show n bytes of memory from addr (64 by default)
+  w addr byte...         write bytes to memory from addr
+  l, list [addr] [n]     disassemble n instructions from addr, or around PC
+  bt, backtrace          show the calls that led to PC, and the last calls and returns that didn't match
+  q, quit                stop the emulator
+An empty line repeats the last command.`
+
+// Debugger is a command line debugger for the game. It reads commands from a reader, one per line, and writes to a writer.
+// Headless, it runs the game itself. With a window, the window runs the frames through Frame while it's not paused,
+// and the commands are run in between.
+type Debugger struct {
+	Game *game.Game
+
+	mu          sync.Mutex
+	out         io.Writer
+	lines       chan string
+	stopped     chan struct{} // Signaled when Frame pauses the game.
+	paused      bool
+	quit        bool
+	breakpoints map[uint16]bool
+	watchpoints []watchpoint
+	watchHits   []string // What the last instruction did to the watched addresses.
+	history     []uint16 // Addresses of the last instructions executed, from oldest to newest.
+	lastCommand string
+	pending     string // Command typed while the game was running, to run once it's paused.
+}
+
+// New returns a debugger for the game, paused before its next instruction.
+func New(g *game.Game, in io.Reader, out io.Writer) *Debugger {
+	d := &Debugger{
+		Game:        g,
+		out:         out,
+		lines:       make(chan string),
+		stopped:     make(chan struct{}, 1),
+		paused:      true,
+		breakpoints: make(map[uint16]bool),
+	}
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			d.lines <- scanner.Text()
+		}
+		close(d.lines)
+	}()
+	return d
+}
+
+// Lock keeps the game from changing, for example while it's drawn.
+func (d *Debugger) Lock() {
+	d.mu.Lock()
+}
+
+func (d *Debugger) Unlock() {
+	d.mu.Unlock()
+}
+
+// Frame runs one frame of the game, unless it's paused. It stops halfway if a breakpoint is hit.
+// It's what the window calls instead of Game.Update, with the lock held.
+func (d *Debugger) Frame() error {
+	if d.quit {
+		return ErrQuit
+	}
+	if d.paused {
+		return nil
+	}
+	if d.Game.Rewinding {
+		return d.Game.Update()
+	}
+	for {
+		frameOver, stop := d.step(nil)
+		if stop != "" {
+			d.pause(stop)
+			return nil
+		}
+		if frameOver {
+			return nil
+		}
+	}
+}
+
+// Run reads and runs commands until the debugger is quit or the input is over.
+// windowed tells whether a window runs the frames through Frame, or they have to be run here.
+func (d *Debugger) Run(windowed bool) {
+	d.mu.Lock()
+	d.printLocation()
+	d.mu.Unlock()
+	for {
+		line, ok := d.pending, true
+		d.pending = ""
+		if line == "" {
+			fmt.Fprint(d.out, "(go-boy) ")
+			line, ok = <-d.lines
+		}
+		if !ok {
+			d.mu.Lock()
+			d.quit = true
+			d.mu.Unlock()
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fields = strings.Fields(d.lastCommand)
+		} else {
+			d.lastCommand = line
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "q" || fields[0] == "quit" {
+			d.mu.Lock()
+			d.quit = true
+			d.mu.Unlock()
+			return
+		}
+		if err := d.command(fields, windowed); err != nil {
+			fmt.Fprintln(d.out, err)
+		}
+	}
+}
+
+// Run a command other than quit.
+func (d *Debugger) command(fields []string, windowed bool) error {
+	args := fields[1:]
+	switch fields[0] {
+	case "h", "help":
+		fmt.Fprintln(d.out, help)
+	case "c", "continue":
+		if windowed {
+			d.resume()
+		} else {
+			d.run(nil)
+		}
+	case "s", "step":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return fmt.Errorf("invalid count %q", args[0])
+			}
+		}
+		d.run(func(executed bool, _ byte) bool {
+			if executed {
+				n--
+			}
+			return n == 0
+		})
+	case "n", "over":
+		d.stepOver()
+	case "o", "out":
+		d.mu.Lock()
+		sp := d.Game.R.SP
+		d.mu.Unlock()
+		// Stacks grow down, so the function has returned once a return takes SP above where it was.
+		d.run(func(executed bool, opcode byte) bool {
+			return executed && disasm.IsReturn(opcode) && d.Game.R.SP > sp
+		})
+	case "b", "break":
+		if len(args) == 0 {
+			d.listBreakpoints()
+			return nil
+		}
+		address, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		d.breakpoints[uint16(address)] = true
+		d.mu.Unlock()
+	case "d", "delete":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if len(args) == 0 {
+			d.breakpoints = make(map[uint16]bool)
+			return nil
+		}
+		address, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		if !d.breakpoints[uint16(address)] {
+			return fmt.Errorf("no breakpoint at %s", d.describe(uint16(address)))
+		}
+		delete(d.breakpoints, uint16(address))
+	case "watch":
+		return d.watch(args)
+	case "unwatch":
+		return d.unwatch(args)
+	case "r", "regs":
+		d.mu.Lock()
+		d.printRegisters()
+		d.mu.Unlock()
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: set reg value")
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.setRegister(strings.ToLower(args[0]), args[1])
+	case "x":
+		return d.examine(args)
+	case "w":
+		return d.write(args)
+	case "l", "list":
+		return d.list(args)
+	case "bt", "backtrace":
+		d.mu.Lock()
+		d.backtrace()
+		d.mu.Unlock()
+	default:
+		return fmt.Errorf("unknown command %q, type help to see them all", fields[0])
+	}
+	return nil
+}
+
+// Execute one instruction, or one cycle if the CPU is halted. done is asked after it whether to stop there.
+// It returns whether the frame is over, and why it has to stop, if it has to.
+// It has to be called with the lock held.
+func (d *Debugger) step(done func(executed bool, opcode byte) bool) (bool, string) {
+	g := d.Game
+	executed := !g.R.Halted
+	pc := g.R.PC
+	opcode := g.M.Read(pc)
+	d.watchHits = d.watchHits[:0]
+	imbalances := 0
+	if g.Calls != nil {
+		imbalances = g.Calls.ImbalanceCount
+	}
+	frameOver, err := g.Step()
+	if err != nil {
+		return false, err.Error()
+	}
+	// Returns that throw calls away are usually bugs, but returns used as jumps are common and not worth stopping at.
+	if g.Calls != nil && g.Calls.ImbalanceCount > imbalances {
+		if i := g.Calls.Imbalances[len(g.Calls.Imbalances)-1]; len(i.Dropped) > 0 {
+			return frameOver, "stack imbalance: " + i.String()
+		}
+	}
+	if len(d.watchHits) > 0 {
+		// Show what did it, since PC has already moved on.
+		return frameOver, fmt.Sprintf("watchpoint: %s\n  by %s", strings.Join(d.watchHits, ", "), d.decode(pc))
+	}
+	if executed {
+		d.history = append(d.history, pc)
+		if len(d.history) > historyLength {
+			d.history = d.history[1:]
+		}
+	}
+	if done != nil && done(executed, opcode) {
+		return frameOver, "done"
+	}
+	if d.breakpoints[g.R.PC] {
+		return frameOver, "breakpoint at " + d.describe(g.R.PC)
+	}
+	return frameOver, ""
+}
+
+// Run the game here until done says so, a breakpoint is hit or a line is typed.
+// The lock is released after every frame, so that a window can draw it.
+func (d *Debugger) run(done func(executed bool, opcode byte) bool) {
+	for {
+		d.mu.Lock()
+		stop := ""
+		for frameOver := false; !frameOver && stop == ""; {
+			frameOver, stop = d.step(done)
+		}
+		if stop != "" {
+			if stop == "done" {
+				stop = ""
+			}
+			d.pause(stop)
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+		select {
+		case line, ok := <-d.lines:
+			d.mu.Lock()
+			d.pause("paused")
+			d.mu.Unlock()
+			d.interrupted(line, ok)
+			return
+		default:
+		}
+	}
+}
+
+// Let the window run frames until one of them pauses the game or a line is typed.
+func (d *Debugger) resume() {
+	d.mu.Lock()
+	select {
+	case <-d.stopped:
+	default:
+	}
+	d.paused = false
+	d.mu.Unlock()
+	select {
+	case <-d.stopped:
+	case line, ok := <-d.lines:
+		d.mu.Lock()
+		if !d.paused {
+			d.pause("paused")
+		}
+		d.mu.Unlock()
+		d.interrupted(line, ok)
+	}
+}
+
+// A line typed while the game was running pauses it. If it's a command, it's run next.
+// If the input is over, the debugger is quit.
+func (d *Debugger) interrupted(line string, ok bool) {
+	if !ok {
+		line = "quit"
+	}
+	d.pending = strings.TrimSpace(line)
+}
+
+// Step over calls and restarts, running until they return to the next instruction.
+func (d *Debugger) stepOver() {
+	d.mu.Lock()
+	r := d.Game.R
+	opcode := d.Game.M.Read(r.PC)
+	if r.Halted || !disasm.IsCall(opcode) {
+		d.mu.Unlock()
+		d.run(func(executed bool, _ byte) bool { return executed })
+		return
+	}
+	next := r.PC + uint16(disasm.Length(opcode))
+	sp := r.SP
+	d.mu.Unlock()
+	// Recursive calls come back to the same address, but deeper in the stack.
+	d.run(func(_ bool, _ byte) bool {
+		return d.Game.R.PC == next && d.Game.R.SP >= sp
+	})
+}
+
+// Pause the game, telling why, and show where it is. It has to be called with the lock held.
+func (d *Debugger) pause(reason string) {
+	d.paused = true
+	if reason != "" {
+		fmt.Fprintln(d.out, reason)
+	}
+	d.printLocation()
+	select {
+	case d.stopped <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Debugger) printLocation() {
+	pc := d.Game.R.PC
+	if label, ok := d.Game.Symbols.Lookup(d.Game.M.ROMBank(), pc); ok {
+		fmt.Fprintf(d.out, "%s:\n", label)
+	}
+	fmt.Fprintln(d.out, d.decode(pc))
+}
+
+func (d *Debugger) decode(address uint16) disasm.Instruction {
+	return disasm.Decode(d.Game.M.Read, address, d.Game.Labels())
+}
+
+// Show the calls that led to PC, and the last imbalances. It has to be called with the lock held.
+func (d *Debugger) backtrace() {
+	calls := d.Game.Calls
+	fmt.Fprint(d.out, d.Game.Backtrace())
+	if calls == nil || calls.ImbalanceCount == 0 {
+		return
+	}
+	fmt.Fprintf(d.out, "%d calls and returns didn't match, the last ones:\n", calls.ImbalanceCount)
+	imbalances := calls.Imbalances
+	if len(imbalances) > historyLength {
+		imbalances = imbalances[len(imbalances)-historyLength:]
+	}
+	for _, i := range imbalances {
+		fmt.Fprintf(d.out, "  %s\n", i)
+	}
+}
+
+func (d *Debugger) printRegisters() {
+	r := d.Game.R
+	flags := []byte("----")
+	for i, set := range []bool{r.ZF, r.NF, r.HF, r.CF} {
+		if set {
+			flags[i] = "ZNHC"[i]
+		}
+	}
+	fmt.Fprintf(d.out, "A:%02X F:%02X [%s] B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X\n",
+		r.A, r.F, flags, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC)
+	fmt.Fprintf(d.out, "IME:%t IE:%02X IF:%02X halted:%t frame:%d\n",
+		d.Game.M.IME, d.Game.M.IER[0], d.Game.M.Read(0xFF0F), r.Halted, d.Game.Frame)
+}
+
+func (d *Debugger) setRegister(name, text string) error {
+	r := d.Game.R
+	registers8 := map[string]*byte{"a": &r.A, "f": &r.F, "b": &r.B, "c": &r.C, "d": &r.D, "e": &r.E, "h": &r.H, "l": &r.L}
+	pairs := map[string][2]*byte{"af": {&r.A, &r.F}, "bc": {&r.B, &r.C}, "de": {&r.D, &r.E}, "hl": {&r.H, &r.L}}
+	if reg, ok := registers8[name]; ok {
+		value, err := parseHex(text, 0xFF)
+		if err != nil {
+			return err
+		}
+		*reg = byte(value)
+	} else if pair, ok := pairs[name]; ok {
+		value, err := parseHex(text, 0xFFFF)
+		if err != nil {
+			return err
+		}
+		*pair[0] = byte(value >> 8)
+		*pair[1] = byte(value)
+	} else if name == "sp" || name == "pc" {
+		value, err := d.parseAddress(text)
+		if err != nil {
+			return err
+		}
+		if name == "sp" {
+			r.SP = uint16(value)
+		} else {
+			r.PC = uint16(value)
+		}
+	} else {
+		return fmt.Errorf("unknown register %q", name)
+	}
+	// The flags are kept as booleans too.
+	r.ZF = r.F&0x80 != 0
+	r.NF = r.F&0x40 != 0
+	r.HF = r.F&0x20 != 0
+	r.CF = r.F&0x10 != 0
+	return nil
+}
+
+func (d *Debugger) listBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "no breakpoints")
+		return
+	}
+	var addresses []int
+	for address := range d.breakpoints {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		fmt.Fprintln(d.out, d.decode(uint16(address)))
+	}
+}
+
+// x addr [n]: hex dump of memory, 16 bytes per line.
+func (d *Debugger) examine(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: x addr [n]")
+	}
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	n := 64
+	if len(args) == 2 {
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := 0; i < n && address+i <= 0xFFFF; i += 16 {
+		line := fmt.Sprintf("%04X ", address+i)
+		for j := i; j < i+16 && j < n && address+j <= 0xFFFF; j++ {
+			line += fmt.Sprintf(" %02X", d.Game.M.Read(uint16(address+j)))
+		}
+		fmt.Fprintln(d.out, line)
+	}
+	return nil
+}
+
+// w addr byte...: write bytes to memory, as the CPU would.
+func (d *Debugger) write(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: w addr byte...")
+	}
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	var values []byte
+	for _, arg := range args[1:] {
+		value, err := parseHex(arg, 0xFF)
+		if err != nil {
+			return err
+		}
+		values = append(values, byte(value))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, value := range values {
+		d.Game.M.Store(uint16(address+i), value)
+	}
+	return nil
+}
+
+// l [addr] [n]: disassemble from an address or, without one, the last instructions executed and the ones after PC.
+func (d *Debugger) list(args []string) error {
+	n := 8
+	var start int
+	var err error
+	if len(args) > 0 {
+		if start, err = d.parseAddress(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pc := d.Game.R.PC
+	address := uint16(start)
+	if len(args) == 0 {
+		for _, past := range d.history {
+			fmt.Fprintln(d.out, "  ", d.decode(past))
+		}
+		address = pc
+	}
+	for i := 0; i < n; i++ {
+		in := d.decode(address)
+		marker := "  "
+		if address == pc {
+			marker = "=>"
+		}
+		if d.breakpoints[address] {
+			marker = marker[:1] + "*"
+		}
+		fmt.Fprintln(d.out, marker, in)
+		address += uint16(len(in.Bytes))
+	}
+	return nil
+}
+
+// Parse a hex number up to max, with or without a $ or 0x in front.
+func parseHex(text string, max int) (int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(text), "$"), "0x")
+	value, err := strconv.ParseUint(trimmed, 16, 32)
+	if err != nil || int(value) > max {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	return int(value), nil
+}
+
+// Parse an address, written either in hex or as the name of a symbol.
+func (d *Debugger) parseAddress(text string) (int, error) {
+	if _, address, ok := d.Game.Symbols.Find(text); ok {
+		return int(address), nil
+	}
+	return parseHex(text, 0xFFFF)
+}
+
+// Write an address with the closest symbol, if there are symbols, or in hex otherwise.
+func (d *Debugger) describe(address uint16) string {
+	if d.Game.Symbols == nil {
+		return fmt.Sprintf("%04X", address)
+	}
+	return d.Game.Symbols.Describe(d.Game.M.ROMBank(), address)
+}
@@ -0,0 +1,116 @@
+package debugger
+
+import (
+	"bytes"
+	"go-boy/internal/callstack"
+	"go-boy/internal/game"
+	"strings"
+	"testing"
+)
+
+// A game that keeps increasing C100 in a function:
+//
+//	0100 LD HL,$C100
+//	0103 CALL $0110
+//	0106 JR $0103
+//	0110 INC (HL)
+//	0111 RET
+func newGame() *game.Game {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{0x21, 0x00, 0xC1, 0xCD, 0x10, 0x01, 0x18, 0xFB})
+	copy(rom[0x110:], []byte{0x34, 0xC9})
+	return game.New(bytes.NewReader(rom))
+}
+
+// A script of commands, and what has to be in the output after running it, in this order.
+type script struct {
+	name  string
+	input string
+	want  []string
+}
+
+func runScripts(t *testing.T, scripts []script) {
+	for _, s := range scripts {
+		t.Run(s.name, func(t *testing.T) {
+			var out bytes.Buffer
+			d := New(newGame(), strings.NewReader(s.input), &out)
+			d.Run(false)
+			rest := out.String()
+			for _, want := range s.want {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("%q not found in the output:\n%s", want, out.String())
+				}
+				rest = rest[i+len(want):]
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	runScripts(t, []script{
+		{"step", "s 2\nr", []string{"SP:DFFE PC:0110"}},
+		{"repeat", "s\n\nr", []string{"PC:0110"}},
+		{"over", "s\nn\nr\nx c100 1", []string{"SP:E000 PC:0106", "C100  01"}},
+		{"breakpoint", "b 110\nc", []string{"breakpoint at 0110\n0110  34        INC (HL)"}},
+		{"out", "b 110\nc\nd 110\no\nr", []string{"breakpoint at 0110", "PC:0106"}},
+		{"list breakpoints", "b\nb 103\nb", []string{"no breakpoints", "0103"}},
+		{"set", "set a 12\nset hl c123\nset pc 110\nr", []string{"A:12", "H:C1 L:23", "PC:0110"}},
+		{"set flags", "set f b0\nr", []string{"F:B0 [Z-HC]"}},
+		{"memory", "w c000 42 43\nx c000 2", []string{"C000  42 43\n"}},
+		{"errors", "b zz\nd 120\nset q 1\nx c000 0\nfoo", []string{
+			`invalid value "zz"`,
+			"no breakpoint at 0120",
+			`unknown register "q"`,
+			`invalid count "0"`,
+			`unknown command "foo"`,
+		}},
+	})
+}
+
+func TestBacktrace(t *testing.T) {
+	var out bytes.Buffer
+	g := newGame()
+	g.Calls = callstack.New()
+	d := New(g, strings.NewReader("b 110\nc\nbt"), &out)
+	d.Run(false)
+	if want := "#0  0110\n#1  0103\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("%q not found in the output:\n%s", want, out.String())
+	}
+}
+
+func TestQuit(t *testing.T) {
+	var out bytes.Buffer
+	g := newGame()
+	d := New(g, strings.NewReader("q\ns"), &out)
+	d.Run(false)
+	if g.R.PC != 0x100 {
+		t.Errorf("the game went on to %04X after quitting", g.R.PC)
+	}
+	if err := d.Frame(); err != ErrQuit {
+		t.Errorf("got %v from Frame after quitting, want ErrQuit", err)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		text  string
+		max   int
+		value int
+		ok    bool
+	}{
+		{"ff", 0xFF, 0xFF, true},
+		{"$C000", 0xFFFF, 0xC000, true},
+		{"0x1a", 0xFF, 0x1A, true},
+		{"100", 0xFF, 0, false},
+		{"", 0xFF, 0, false},
+		{"zz", 0xFFFF, 0, false},
+		{"-1", 0xFFFF, 0, false},
+	}
+	for _, test := range tests {
+		value, err := parseHex(test.text, test.max)
+		if (err == nil) != test.ok || value != test.value {
+			t.Errorf("parseHex(%q, %X) = %X, %v", test.text, test.max, value, err)
+		}
+	}
+}
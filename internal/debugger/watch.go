@@ -0,0 +1,128 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Watchpoint pauses the game when an instruction reads or writes an address in a range.
+type watchpoint struct {
+	start, end uint16 // Both included.
+	read       bool
+	write      bool
+	change     bool // Only writes that change the value.
+}
+
+func (w watchpoint) String() string {
+	var kind string
+	switch {
+	case w.read && w.write:
+		kind = "rw"
+	case w.read:
+		kind = "r"
+	case w.write:
+		kind = "w"
+	case w.change:
+		kind = "c"
+	}
+	if w.start == w.end {
+		return fmt.Sprintf("%-2s %04X", kind, w.start)
+	}
+	return fmt.Sprintf("%-2s %04X-%04X", kind, w.start, w.end)
+}
+
+func (w watchpoint) contains(address uint16) bool {
+	return address >= w.start && address <= w.end
+}
+
+// Read implements memory.Watcher, while there are watchpoints. A hit is kept until the instruction is over.
+func (d *Debugger) Read(address uint16, value byte) {
+	for _, w := range d.watchpoints {
+		if w.read && w.contains(address) {
+			d.watchHits = append(d.watchHits, fmt.Sprintf("read %02X from %04X", value, address))
+			return
+		}
+	}
+}
+
+// Write implements memory.Watcher. Writing the same value only hits the watchpoints that aren't on changes.
+func (d *Debugger) Write(address uint16, old, n byte) {
+	for _, w := range d.watchpoints {
+		if w.contains(address) && (w.write || w.change && old != n) {
+			d.watchHits = append(d.watchHits, fmt.Sprintf("wrote %02X to %04X (was %02X)", n, address, old))
+			return
+		}
+	}
+}
+
+// watch [r|w|rw|c] addr[-end]: add a watchpoint, on writes by default. Without arguments, list them.
+func (d *Debugger) watch(args []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(args) == 0 {
+		if len(d.watchpoints) == 0 {
+			fmt.Fprintln(d.out, "no watchpoints")
+		}
+		for i, w := range d.watchpoints {
+			fmt.Fprintf(d.out, "%d: %s\n", i+1, w)
+		}
+		return nil
+	}
+	w := watchpoint{write: true}
+	if len(args) == 2 {
+		w.write = false
+		switch args[0] {
+		case "r":
+			w.read = true
+		case "w":
+			w.write = true
+		case "rw":
+			w.read, w.write = true, true
+		case "c":
+			w.change = true
+		default:
+			return fmt.Errorf("unknown kind of watchpoint %q, it has to be r, w, rw or c", args[0])
+		}
+		args = args[1:]
+	} else if len(args) != 1 {
+		return fmt.Errorf("usage: watch [r|w|rw|c] addr[-end]")
+	}
+	bounds := strings.SplitN(args[0], "-", 2)
+	start, err := d.parseAddress(bounds[0])
+	if err != nil {
+		return err
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = d.parseAddress(bounds[1]); err != nil {
+			return err
+		}
+		if end < start {
+			return fmt.Errorf("the range %s ends before it starts", args[0])
+		}
+	}
+	w.start, w.end = uint16(start), uint16(end)
+	d.watchpoints = append(d.watchpoints, w)
+	d.Game.Watch = d
+	return nil
+}
+
+// unwatch [n]: delete watchpoint number n, as listed by watch, or all of them.
+func (d *Debugger) unwatch(args []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(args) == 0 {
+		d.watchpoints = nil
+	} else {
+		var n int
+		if _, err := fmt.Sscan(args[0], &n); err != nil || n < 1 || n > len(d.watchpoints) {
+			return fmt.Errorf("no watchpoint %q", args[0])
+		}
+		d.watchpoints = append(d.watchpoints[:n-1], d.watchpoints[n:]...)
+	}
+	// Nothing to watch, so don't slow the memory down.
+	if len(d.watchpoints) == 0 {
+		d.Game.Watch = nil
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package debugger
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWatchpoints(t *testing.T) {
+	runScripts(t, []script{
+		{"writes", "watch c100\nc", []string{"watchpoint: wrote 01 to C100 (was 00)\n  by 0110  34        INC (HL)"}},
+		{"reads", "watch r c0ff-c100\nc", []string{"watchpoint: read 00 from C100\n  by 0110"}},
+		{"changes", "watch c c100\nc\nc", []string{"wrote 01 to C100 (was 00)", "wrote 02 to C100 (was 01)"}},
+		{"list", "watch\nwatch c c000\nwatch rw d000-d00f\nwatch", []string{"no watchpoints", "1: c  C000\n2: rw D000-D00F\n"}},
+		{"unwatch one", "watch c000\nwatch d000\nunwatch 1\nwatch", []string{"1: w  D000\n"}},
+		{"unwatch all", "watch c000\nwatch d000\nunwatch\nwatch", []string{"no watchpoints"}},
+		{"errors", "watch q c000\nwatch d000-c000\nwatch r c000 d000\nunwatch 3\nunwatch x", []string{
+			`unknown kind of watchpoint "q"`,
+			"the range d000-c000 ends before it starts",
+			"usage: watch [r|w|rw|c] addr[-end]",
+			`no watchpoint "3"`,
+			`no watchpoint "x"`,
+		}},
+	})
+}
+
+func TestWatchpointContains(t *testing.T) {
+	w := watchpoint{start: 0xC000, end: 0xC00F, write: true}
+	tests := []struct {
+		address uint16
+		want    bool
+	}{
+		{0xBFFF, false},
+		{0xC000, true},
+		{0xC008, true},
+		{0xC00F, true},
+		{0xC010, false},
+	}
+	for _, test := range tests {
+		if got := w.contains(test.address); got != test.want {
+			t.Errorf("contains(%04X) = %t, want %t", test.address, got, test.want)
+		}
+	}
+}
+
+func TestWatchHits(t *testing.T) {
+	tests := []struct {
+		kind        string
+		read, write bool // Whether each access is a hit.
+		same        bool // Whether writing the same value is.
+	}{
+		{"r", true, false, false},
+		{"w", false, true, true},
+		{"rw", true, true, true},
+		{"c", false, true, false},
+	}
+	for _, test := range tests {
+		d := New(newGame(), strings.NewReader(""), io.Discard)
+		if err := d.watch([]string{test.kind, "c100"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, access := range []struct {
+			do  func()
+			hit bool
+		}{
+			{func() { d.Read(0xC100, 1) }, test.read},
+			{func() { d.Write(0xC100, 1, 2) }, test.write},
+			{func() { d.Write(0xC100, 2, 2) }, test.same},
+			{func() { d.Write(0xC101, 1, 2) }, false},
+		} {
+			d.watchHits = d.watchHits[:0]
+			access.do()
+			if hit := len(d.watchHits) > 0; hit != access.hit {
+				t.Errorf("%s: got hits %q", test.kind, d.watchHits)
+			}
+		}
+	}
+}
+
+func TestUnwatchStopsWatching(t *testing.T) {
+	g := newGame()
+	d := New(g, strings.NewReader(""), io.Discard)
+	if err := d.watch([]string{"c100"}); err != nil {
+		t.Fatal(err)
+	}
+	if g.Watch != d {
+		t.Fatal("the game isn't watched with a watchpoint")
+	}
+	if err := d.unwatch(nil); err != nil {
+		t.Fatal(err)
+	}
+	if g.Watch != nil {
+		t.Error("the game is still watched without watchpoints")
+	}
+}
@@ -0,0 +1,261 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"go-boy/internal/coverage"
+	"io"
+	"strings"
+)
+
+// Mnemonics of every opcode. The operands are written as:
+// d8 and d16 for immediate data, a8 for an address in the FF00 page, a16 for an address and r8 for a relative jump.
+// Opcodes that don't exist are left empty.
+var mnemonics = [256]string{
+	"NOP", "LD BC,d16", "LD (BC),A", "INC BC", "INC B", "DEC B", "LD B,d8", "RLCA", // 0x00
+	"LD (a16),SP", "ADD HL,BC", "LD A,(BC)", "DEC BC", "INC C", "DEC C", "LD C,d8", "RRCA",
+	"STOP", "LD DE,d16", "LD (DE),A", "INC DE", "INC D", "DEC D", "LD D,d8", "RLA", // 0x10
+	"JR r8", "ADD HL,DE", "LD A,(DE)", "DEC DE", "INC E", "DEC E", "LD E,d8", "RRA",
+	"JR NZ,r8", "LD HL,d16", "LD (HL+),A", "INC HL", "INC H", "DEC H", "LD H,d8", "DAA", // 0x20
+	"JR Z,r8", "ADD HL,HL", "LD A,(HL+)", "DEC HL", "INC L", "DEC L", "LD L,d8", "CPL",
+	"JR NC,r8", "LD SP,d16", "LD (HL-),A", "INC SP", "INC (HL)", "DEC (HL)", "LD (HL),d8", "SCF", // 0x30
+	"JR C,r8", "ADD HL,SP", "LD A,(HL-)", "DEC SP", "INC A", "DEC A", "LD A,d8", "CCF",
+	// 0x40 - 0xBF are filled in init.
+	0xC0: "RET NZ", "POP BC", "JP NZ,a16", "JP a16", "CALL NZ,a16", "PUSH BC", "ADD A,d8", "RST 00H",
+	"RET Z", "RET", "JP Z,a16", "PREFIX CB", "CALL Z,a16", "CALL a16", "ADC A,d8", "RST 08H",
+	"RET NC", "POP DE", "JP NC,a16", "", "CALL NC,a16", "PUSH DE", "SUB d8", "RST 10H", // 0xD0
+	"RET C", "RETI", "JP C,a16", "", "CALL C,a16", "", "SBC A,d8", "RST 18H",
+	"LDH (a8),A", "POP HL", "LD (C),A", "", "", "PUSH HL", "AND d8", "RST 20H", // 0xE0
+	"ADD SP,r8", "JP (HL)", "LD (a16),A", "", "", "", "XOR d8", "RST 28H",
+	"LDH A,(a8)", "POP AF", "LD A,(C)", "DI", "", "PUSH AF", "OR d8", "RST 30H", // 0xF0
+	"LD HL,SP+r8", "LD SP,HL", "LD A,(a16)", "EI", "", "", "CP d8", "RST 38H",
+}
+
+// Mnemonics of the opcodes after the 0xCB prefix. All of them are generated in init.
+var cbMnemonics [256]string
+
+// Operands of the opcodes that work on any of the 8 bit registers, in the order they're encoded.
+var registerOperands = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+func init() {
+	// 0x40 - 0x7F: loads between registers, but (HL) to (HL) is HALT.
+	for op := 0x40; op < 0x80; op++ {
+		mnemonics[op] = "LD " + registerOperands[op>>3&0x07] + "," + registerOperands[op&0x07]
+	}
+	mnemonics[0x76] = "HALT"
+	// 0x80 - 0xBF: arithmetic with A.
+	alu := [8]string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	for op := 0x80; op < 0xC0; op++ {
+		mnemonics[op] = alu[op>>3&0x07] + registerOperands[op&0x07]
+	}
+	// CB: rotations and shifts, then bit tests, resets and sets.
+	shifts := [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+	for op := 0; op < 0x100; op++ {
+		reg := registerOperands[op&0x07]
+		bit := op >> 3 & 0x07
+		switch op >> 6 {
+		case 0:
+			cbMnemonics[op] = shifts[bit] + " " + reg
+		case 1:
+			cbMnemonics[op] = fmt.Sprintf("BIT %d,%s", bit, reg)
+		case 2:
+			cbMnemonics[op] = fmt.Sprintf("RES %d,%s", bit, reg)
+		case 3:
+			cbMnemonics[op] = fmt.Sprintf("SET %d,%s", bit, reg)
+		}
+	}
+}
+
+// Length returns how many bytes the instruction with the given opcode takes, counting the opcode.
+func Length(opcode byte) int {
+	m := mnemonics[opcode]
+	switch {
+	case opcode == 0xCB || opcode == 0x10:
+		// STOP is followed by a byte that's ignored.
+		return 2
+	case strings.Contains(m, "d16") || strings.Contains(m, "a16"):
+		return 3
+	case strings.Contains(m, "d8") || strings.Contains(m, "a8") || strings.Contains(m, "r8"):
+		return 2
+	}
+	return 1
+}
+
+// Instruction is an instruction decoded from memory.
+type Instruction struct {
+	Address uint16
+	Bytes   []byte
+	// Mnemonic with the operands filled in, like "LD A,$12" or "JR NZ,$0150".
+	Text string
+	// Address jumped or called to, for jumps, calls and restarts with a fixed one.
+	Target    uint16
+	HasTarget bool
+}
+
+// Labels names addresses, like the functions and variables in a symbol file.
+type Labels interface {
+	Label(address uint16) (string, bool)
+}
+
+// Decode reads the instruction at the given address, using read to get the bytes.
+// With labels, the addresses in the operands that have one are written with it.
+func Decode(read func(address uint16) byte, address uint16, labels Labels) Instruction {
+	opcode := read(address)
+	in := Instruction{Address: address, Bytes: make([]byte, Length(opcode))}
+	for i := range in.Bytes {
+		in.Bytes[i] = read(address + uint16(i))
+	}
+
+	if opcode == 0xCB {
+		in.Text = cbMnemonics[in.Bytes[1]]
+		return in
+	}
+	m := mnemonics[opcode]
+	if m == "" {
+		in.Text = fmt.Sprintf("DB $%02X", opcode)
+		return in
+	}
+	next := address + uint16(len(in.Bytes))
+	name := func(address uint16, text string) string {
+		if labels != nil {
+			if label, ok := labels.Label(address); ok {
+				return label
+			}
+		}
+		return text
+	}
+	switch {
+	case strings.Contains(m, "d16"):
+		m = strings.Replace(m, "d16", fmt.Sprintf("$%04X", in.immediate16()), 1)
+	case strings.Contains(m, "a16"):
+		address := in.immediate16()
+		if strings.HasPrefix(m, "JP") || strings.HasPrefix(m, "CALL") {
+			in.Target = address
+			in.HasTarget = true
+		}
+		m = strings.Replace(m, "a16", name(address, fmt.Sprintf("$%04X", address)), 1)
+	case strings.Contains(m, "d8"):
+		m = strings.Replace(m, "d8", fmt.Sprintf("$%02X", in.Bytes[1]), 1)
+	case strings.Contains(m, "a8"):
+		address := 0xFF00 | uint16(in.Bytes[1])
+		m = strings.Replace(m, "a8", name(address, fmt.Sprintf("$%04X", address)), 1)
+	case strings.HasPrefix(m, "JR"):
+		in.Target = next + uint16(int8(in.Bytes[1]))
+		in.HasTarget = true
+		m = strings.Replace(m, "r8", name(in.Target, fmt.Sprintf("$%04X", in.Target)), 1)
+	case strings.Contains(m, "+r8"):
+		m = strings.Replace(m, "+r8", fmt.Sprintf("%+d", int8(in.Bytes[1])), 1)
+	case strings.Contains(m, "r8"):
+		m = strings.Replace(m, "r8", fmt.Sprintf("%d", int8(in.Bytes[1])), 1)
+	case strings.HasPrefix(m, "RST"):
+		in.Target = uint16(opcode & 0x38)
+		in.HasTarget = true
+		if label := name(in.Target, ""); label != "" {
+			m = "RST " + label
+		}
+	}
+	in.Text = m
+	return in
+}
+
+func (in Instruction) immediate16() uint16 {
+	return uint16(in.Bytes[1]) | uint16(in.Bytes[2])<<8
+}
+
+// String returns the instruction as it's printed in listings: address, bytes and mnemonic.
+func (in Instruction) String() string {
+	var bytes strings.Builder
+	for _, b := range in.Bytes {
+		fmt.Fprintf(&bytes, "%02X ", b)
+	}
+	return fmt.Sprintf("%04X  %-9s %s", in.Address, bytes.String(), in.Text)
+}
+
+// IsCall tells whether the opcode is a call or a restart, which return to the next instruction.
+func IsCall(opcode byte) bool {
+	return strings.HasPrefix(mnemonics[opcode], "CALL") || strings.HasPrefix(mnemonics[opcode], "RST")
+}
+
+// IsReturn tells whether the opcode is one of the returns.
+func IsReturn(opcode byte) bool {
+	return strings.HasPrefix(mnemonics[opcode], "RET")
+}
+
+// WriteBank disassembles a whole ROM bank into w, one instruction per line, with the names of the symbols
+// before the addresses they name. Everything is taken as code, data included, unless there's a code/data log with the
+// flags of every ROM byte, as recorded by the coverage package. Then the bytes that were read but never executed
+// are written as data. symbols and cdl can be nil.
+func WriteBank(w io.Writer, rom []byte, bank int, symbols *Symbols, cdl []byte) error {
+	if bank < 0 || bank*coverage.BankSize >= len(rom) {
+		return fmt.Errorf("there's no bank %d in a ROM of %d bytes", bank, len(rom))
+	}
+	start := uint16(0)
+	if bank > 0 {
+		start = coverage.BankSize
+	}
+	data := rom[bank*coverage.BankSize:]
+	if len(data) > coverage.BankSize {
+		data = data[:coverage.BankSize]
+	}
+	read := func(address uint16) byte {
+		if offset := int(address - start); address >= start && offset < len(data) {
+			return data[offset]
+		}
+		return 0xFF
+	}
+	var labels Labels
+	if symbols != nil {
+		labels = symbols.InBank(bank)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; Bank %d\n", bank)
+	end := int(start) + len(data)
+	isData := func(address int) bool {
+		if cdl == nil {
+			return false
+		}
+		flags := cdl[bank*coverage.BankSize+address-int(start)]
+		return flags&coverage.Data != 0 && flags&(coverage.Opcode|coverage.Operand) == 0
+	}
+	for address := int(start); address < end; {
+		if labels != nil {
+			if label, ok := labels.Label(uint16(address)); ok {
+				fmt.Fprintf(bw, "%s:\n", label)
+			}
+		}
+		if isData(address) {
+			// Up to 8 bytes of data per line, until a label or something that isn't data.
+			n := 1
+			for n < 8 && address+n < end && isData(address+n) {
+				if labels != nil {
+					if _, ok := labels.Label(uint16(address + n)); ok {
+						break
+					}
+				}
+				n++
+			}
+			values := make([]string, n)
+			for i := range values {
+				values[i] = fmt.Sprintf("$%02X", read(uint16(address+i)))
+			}
+			fmt.Fprintf(bw, "  %04X  %-9s DB %s\n", address, "", strings.Join(values, ","))
+			address += n
+			continue
+		}
+		in := Decode(read, uint16(address), labels)
+		// The last instruction can't go past the end of the bank.
+		if address+len(in.Bytes) > end {
+			in.Bytes = in.Bytes[:end-address]
+			values := make([]string, len(in.Bytes))
+			for i, b := range in.Bytes {
+				values[i] = fmt.Sprintf("$%02X", b)
+			}
+			in.Text = "DB " + strings.Join(values, ",")
+			in.HasTarget = false
+		}
+		fmt.Fprintf(bw, "  %s\n", in)
+		address += len(in.Bytes)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,189 @@
+package disasm
+
+import (
+	"bytes"
+	"go-boy/internal/coverage"
+	"strings"
+	"testing"
+)
+
+// Labels from a map, for the tests.
+type labelMap map[uint16]string
+
+func (l labelMap) Label(address uint16) (string, bool) {
+	label, ok := l[address]
+	return label, ok
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		code      []byte
+		text      string
+		target    uint16
+		hasTarget bool
+	}{
+		{[]byte{0x00}, "NOP", 0, false},
+		{[]byte{0x3E, 0x48}, "LD A,$48", 0, false},
+		{[]byte{0x21, 0x34, 0x12}, "LD HL,$1234", 0, false},
+		{[]byte{0x78}, "LD A,B", 0, false},
+		{[]byte{0x76}, "HALT", 0, false},
+		{[]byte{0x86}, "ADD A,(HL)", 0, false},
+		{[]byte{0xAF}, "XOR A", 0, false},
+		{[]byte{0xCB, 0x37}, "SWAP A", 0, false},
+		{[]byte{0xCB, 0x7E}, "BIT 7,(HL)", 0, false},
+		{[]byte{0xCB, 0xC1}, "SET 0,C", 0, false},
+		{[]byte{0xE0, 0x40}, "LDH ($FF40),A", 0, false},
+		{[]byte{0xEA, 0x00, 0xC0}, "LD ($C000),A", 0, false},
+		{[]byte{0xC3, 0x50, 0x01}, "JP $0150", 0x0150, true},
+		{[]byte{0xCD, 0x00, 0x40}, "CALL $4000", 0x4000, true},
+		{[]byte{0x20, 0xFE}, "JR NZ,$0200", 0x0200, true},
+		{[]byte{0x18, 0x10}, "JR $0212", 0x0212, true},
+		{[]byte{0xFF}, "RST 38H", 0x0038, true},
+		{[]byte{0xE8, 0xFE}, "ADD SP,-2", 0, false},
+		{[]byte{0xF8, 0x05}, "LD HL,SP+5", 0, false},
+		{[]byte{0xD3}, "DB $D3", 0, false},
+	}
+	for _, test := range tests {
+		// Everything is decoded at 0200.
+		read := func(address uint16) byte {
+			if offset := int(address) - 0x200; offset >= 0 && offset < len(test.code) {
+				return test.code[offset]
+			}
+			return 0
+		}
+		in := Decode(read, 0x200, nil)
+		if in.Text != test.text || in.Target != test.target || in.HasTarget != test.hasTarget {
+			t.Errorf("% X: got %q, target %04X %t, want %q, target %04X %t",
+				test.code, in.Text, in.Target, in.HasTarget, test.text, test.target, test.hasTarget)
+		}
+		if !bytes.Equal(in.Bytes, test.code) {
+			t.Errorf("% X: decoded % X", test.code, in.Bytes)
+		}
+	}
+}
+
+func TestDecodeLabels(t *testing.T) {
+	labels := labelMap{0x0150: "Start", 0xFF40: "rLCDC", 0x0038: "Crash"}
+	tests := []struct {
+		code []byte
+		text string
+	}{
+		{[]byte{0xC3, 0x50, 0x01}, "JP Start"},
+		{[]byte{0xE0, 0x40}, "LDH (rLCDC),A"},
+		{[]byte{0xFF}, "RST Crash"},
+		{[]byte{0xC7}, "RST 00H"},
+		// Immediate data isn't an address.
+		{[]byte{0x21, 0x50, 0x01}, "LD HL,$0150"},
+	}
+	for _, test := range tests {
+		read := func(address uint16) byte { return test.code[int(address)%len(test.code)] }
+		if in := Decode(read, 0, labels); in.Text != test.text {
+			t.Errorf("% X: got %q, want %q", test.code, in.Text, test.text)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		opcode byte
+		length int
+	}{
+		{0x00, 1}, {0x10, 2}, {0x01, 3}, {0x06, 2}, {0x18, 2}, {0xCB, 2},
+		{0xC3, 3}, {0xE0, 2}, {0xE2, 1}, {0xE8, 2}, {0xEA, 3}, {0xD3, 1},
+	}
+	for _, test := range tests {
+		if length := Length(test.opcode); length != test.length {
+			t.Errorf("Length(%02X) = %d, want %d", test.opcode, length, test.length)
+		}
+	}
+}
+
+func TestCallsAndReturns(t *testing.T) {
+	tests := []struct {
+		opcode         byte
+		call, isReturn bool
+	}{
+		{0xCD, true, false},
+		{0xC4, true, false},
+		{0xDF, true, false},
+		{0xC9, false, true},
+		{0xD8, false, true},
+		{0xD9, false, true},
+		{0xC3, false, false},
+		{0x18, false, false},
+	}
+	for _, test := range tests {
+		if IsCall(test.opcode) != test.call || IsReturn(test.opcode) != test.isReturn {
+			t.Errorf("%02X: got call %t, return %t", test.opcode, IsCall(test.opcode), IsReturn(test.opcode))
+		}
+	}
+}
+
+func TestWriteBank(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	for i := range rom {
+		rom[i] = 0xFF
+	}
+	// The last instruction of bank 1 is cut short by the end of the bank.
+	copy(rom[0x4000:], []byte{0x3E, 0x48, 0xC9})
+	rom[0x7FFF] = 0xC3
+	var b strings.Builder
+	if err := WriteBank(&b, rom, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	want := []string{
+		"; Bank 1",
+		"  4000  3E 48     LD A,$48",
+		"  4002  C9        RET",
+		"  4003  FF        RST 38H",
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("line %d: got %q, want %q", i, lines[i], line)
+		}
+	}
+	if last := lines[len(lines)-1]; last != "  7FFF  C3        DB $C3" {
+		t.Errorf("got %q for the last instruction", last)
+	}
+
+	// All the bytes left of an instruction are kept.
+	copy(rom[0x7FFD:], []byte{0x00, 0xC3, 0x50})
+	b.Reset()
+	if err := WriteBank(&b, rom, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "  7FFD  00        NOP\n  7FFE  C3 50     DB $C3,$50\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %q at the end of the bank, want %q", got[len(got)-len(want):], want)
+	}
+
+	for _, bank := range []int{2, -1} {
+		if err := WriteBank(&b, rom, bank, nil, nil); err == nil {
+			t.Errorf("no error writing bank %d of 2", bank)
+		}
+	}
+}
+
+func TestWriteBankWithLog(t *testing.T) {
+	rom := make([]byte, 2*coverage.BankSize)
+	copy(rom, []byte{0x3E, 0x48, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xC9})
+	cdl := make([]byte, len(rom))
+	cdl[0], cdl[1] = coverage.Opcode, coverage.Operand
+	// Data read, up to 8 bytes a line, and a byte both read and executed, which is code.
+	for i := 2; i <= 11; i++ {
+		cdl[i] = coverage.Data
+	}
+	cdl[11] |= coverage.Opcode
+	var b strings.Builder
+	if err := WriteBank(&b, rom, 0, nil, cdl); err != nil {
+		t.Fatal(err)
+	}
+	want := "; Bank 0\n" +
+		"  0000  3E 48     LD A,$48\n" +
+		"  0002            DB $11,$22,$33,$44,$55,$66,$77,$88\n" +
+		"  000A            DB $99\n" +
+		"  000B  C9        RET\n"
+	if got := b.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", got[:len(want)], want)
+	}
+}
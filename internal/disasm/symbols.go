@@ -0,0 +1,257 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbols are the names of addresses in a game, as found in the symbol files made by assemblers like RGBDS.
+// Addresses in the switchable ROM bank (4000 - 7FFF) mean something different in each bank, so they're kept by bank.
+// RAM banks can't be told apart from here, so the rest of the addresses get their name from bank 0 or,
+// if they have none there, from the first bank they're named in.
+type Symbols struct {
+	names    map[uint32]string
+	unbanked map[uint16]string
+	sorted   map[int][]symbol // Symbols of each bank by address, made when they're first needed.
+}
+
+type symbol struct {
+	address uint16
+	name    string
+}
+
+func symbolKey(bank int, address uint16) uint32 {
+	return uint32(bank)<<16 | uint32(address)
+}
+
+func switchable(address uint16) bool {
+	return address >= 0x4000 && address < 0x8000
+}
+
+// NewSymbols returns an empty set of symbols.
+func NewSymbols() *Symbols {
+	return &Symbols{names: make(map[uint32]string), unbanked: make(map[uint16]string)}
+}
+
+// Add names an address in a bank. If it already has a name, the first one is kept.
+func (s *Symbols) Add(bank int, address uint16, name string) {
+	key := symbolKey(bank, address)
+	if _, ok := s.names[key]; ok {
+		return
+	}
+	s.names[key] = name
+	if _, ok := s.unbanked[address]; !switchable(address) && (!ok || bank == 0) {
+		s.unbanked[address] = name
+	}
+	s.sorted = nil
+}
+
+// Len returns how many symbols there are.
+func (s *Symbols) Len() int {
+	return len(s.names)
+}
+
+// Lookup returns the name of an address, as seen with the given ROM bank switched in.
+// Like the rest of the methods that don't add symbols, it can be called on nil symbols, which have no names.
+func (s *Symbols) Lookup(bank int, address uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	if !switchable(address) {
+		name, ok := s.unbanked[address]
+		return name, ok
+	}
+	name, ok := s.names[symbolKey(bank, address)]
+	return name, ok
+}
+
+// Find returns the address of the symbol with the given name, and its bank.
+// If several banks have a symbol with that name, the lowest bank wins, and then the lowest address.
+func (s *Symbols) Find(name string) (int, uint16, bool) {
+	if s == nil {
+		return 0, 0, false
+	}
+	found := false
+	var first uint32
+	for key, n := range s.names {
+		if n == name && (!found || key < first) {
+			found, first = true, key
+		}
+	}
+	return int(first >> 16), uint16(first), found
+}
+
+// Describe writes an address as the closest symbol at or before it plus the offset from it, like "Start+2",
+// as seen with the given ROM bank switched in. Without one, it's written in hex.
+func (s *Symbols) Describe(bank int, address uint16) string {
+	if s != nil {
+		if name, offset, ok := s.nearest(bank, address); ok && offset == 0 {
+			return name
+		} else if ok {
+			return fmt.Sprintf("%s+%d", name, offset)
+		}
+	}
+	return fmt.Sprintf("$%04X", address)
+}
+
+// Function returns the name of the function an address is in, as seen with the given ROM bank switched in: the closest
+// symbol at or before it, without the local part of RGBDS local labels, so "Main.loop" is in "Main".
+func (s *Symbols) Function(bank int, address uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	name, _, ok := s.nearest(bank, address)
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name, ok
+}
+
+// Find the closest symbol before an address, in the same part of the memory.
+func (s *Symbols) nearest(bank int, address uint16) (string, int, bool) {
+	if !switchable(address) {
+		bank = -1
+	}
+	if s.sorted == nil {
+		s.sorted = make(map[int][]symbol)
+		for key, name := range s.names {
+			if a := uint16(key); switchable(a) {
+				s.sorted[int(key>>16)] = append(s.sorted[int(key>>16)], symbol{a, name})
+			}
+		}
+		for a, name := range s.unbanked {
+			s.sorted[-1] = append(s.sorted[-1], symbol{a, name})
+		}
+		for _, symbols := range s.sorted {
+			sort.Slice(symbols, func(i, j int) bool { return symbols[i].address < symbols[j].address })
+		}
+	}
+	symbols := s.sorted[bank]
+	i := sort.Search(len(symbols), func(i int) bool { return symbols[i].address > address }) - 1
+	// Don't go looking for it in another part of the memory, like the end of ROM for an address in RAM.
+	if i < 0 || region(symbols[i].address) != region(address) {
+		return "", 0, false
+	}
+	return symbols[i].name, int(address - symbols[i].address), true
+}
+
+// Parts of the memory a symbol can be in: ROM, VRAM, SRAM, WRAM, and everything from OAM up.
+func region(address uint16) int {
+	switch {
+	case address < 0x8000:
+		return 0
+	case address < 0xA000:
+		return 1
+	case address < 0xC000:
+		return 2
+	case address < 0xFE00:
+		return 3
+	}
+	return 4
+}
+
+// InBank returns the labels seen with the given ROM bank switched in, for Decode.
+func (s *Symbols) InBank(bank int) Labels {
+	return bankLabels{s, bank}
+}
+
+type bankLabels struct {
+	symbols *Symbols
+	bank    int
+}
+
+func (l bankLabels) Label(address uint16) (string, bool) {
+	return l.symbols.Lookup(l.bank, address)
+}
+
+// ReadSymbols reads a .sym file, which has a symbol per line written as "bank:address name", both in hex,
+// like "01:4A20 UpdatePlayer". Everything after a ";" is a comment.
+func ReadSymbols(r io.Reader) (*Symbols, error) {
+	s := NewSymbols()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		location := strings.SplitN(fields[0], ":", 2)
+		if len(fields) != 2 || len(location) != 2 {
+			return nil, fmt.Errorf("line %d: expected bank:address name, got %q", n, scanner.Text())
+		}
+		bank, err := strconv.ParseUint(location[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid bank %q", n, location[0])
+		}
+		address, err := strconv.ParseUint(location[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", n, location[1])
+		}
+		s.Add(int(bank), uint16(address), fields[1])
+	}
+	return s, scanner.Err()
+}
+
+// Lines of a .map file with the kind of memory and the bank the symbols after them are in, like "ROMX bank #2:",
+// and with a symbol, like "$4A20 = UpdatePlayer".
+var (
+	mapBank   = regexp.MustCompile(`^\s*(\w+) bank #(\d+):`)
+	mapSymbol = regexp.MustCompile(`^\s*\$([0-9A-Fa-f]{1,4}) = (\S+)`)
+)
+
+// ReadMap reads the symbols in a .map file made by RGBDS.
+func ReadMap(r io.Reader) (*Symbols, error) {
+	s := NewSymbols()
+	bank := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := mapBank.FindStringSubmatch(line); match != nil {
+			bank, _ = strconv.Atoi(match[2])
+		} else if match := mapSymbol.FindStringSubmatch(line); match != nil {
+			address, _ := strconv.ParseUint(match[1], 16, 16)
+			s.Add(bank, uint16(address), match[2])
+		}
+	}
+	return s, scanner.Err()
+}
+
+// LoadSymbols reads a .sym file or, if its extension is .map, a .map file.
+func LoadSymbols(filename string) (*Symbols, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	read := ReadSymbols
+	if strings.EqualFold(filepath.Ext(filename), ".map") {
+		read = ReadMap
+	}
+	s, err := read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return s, nil
+}
+
+// FindSymbols loads the symbol file next to a ROM, with the same name but ending in .sym or, failing that, .map.
+// It returns nil if there's none.
+func FindSymbols(romFilename string) (*Symbols, error) {
+	base := strings.TrimSuffix(romFilename, filepath.Ext(romFilename))
+	for _, ext := range []string{".sym", ".map"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return LoadSymbols(base + ext)
+		}
+	}
+	return nil, nil
+}
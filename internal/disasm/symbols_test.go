@@ -0,0 +1,188 @@
+package disasm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	s := NewSymbols()
+	s.Add(3, 0x4000, "Shared")
+	s.Add(1, 0x5000, "Shared")
+	s.Add(2, 0x4000, "Shared")
+	s.Add(0, 0x0150, "Start")
+	s.Add(1, 0x4100, "Twice")
+	s.Add(1, 0x4010, "Twice")
+	tests := []struct {
+		name    string
+		bank    int
+		address uint16
+		found   bool
+	}{
+		{"Start", 0, 0x0150, true},
+		// The lowest bank, and then the lowest address, wins every time.
+		{"Shared", 1, 0x5000, true},
+		{"Twice", 1, 0x4010, true},
+		{"Missing", 0, 0, false},
+	}
+	for _, test := range tests {
+		// Maps are iterated in a different order every time, so try a few times.
+		for i := 0; i < 20; i++ {
+			bank, address, found := s.Find(test.name)
+			if bank != test.bank || address != test.address || found != test.found {
+				t.Fatalf("Find(%q): got %d:%04X, %v, want %d:%04X, %v",
+					test.name, bank, address, found, test.bank, test.address, test.found)
+			}
+		}
+	}
+	var none *Symbols
+	if _, _, found := none.Find("Start"); found {
+		t.Errorf("found a symbol without symbols")
+	}
+}
+
+func TestReadSymbols(t *testing.T) {
+	s, err := ReadSymbols(strings.NewReader(`; File generated by rgblink
+
+00:0150 Start
+00:0150 Entry ; Only the first name is kept.
+01:4a20 UpdatePlayer
+02:4A20 UpdateEnemies
+00:c0a0 wPlayerX
+00:FF80 hFrameCounter
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 5 {
+		t.Errorf("got %d symbols, want 5", s.Len())
+	}
+	checkLookups(t, s)
+}
+
+func TestReadMap(t *testing.T) {
+	s, err := ReadMap(strings.NewReader(`ROM0 bank #0:
+  SECTION: $0150-$01FF ($00B0 bytes) ["Main"]
+           $0150 = Start
+           $0150 = Entry
+ROMX bank #1:
+  SECTION: $4A20-$4AFF ($00E0 bytes) ["Player"]
+           $4A20 = UpdatePlayer
+ROMX bank #2:
+  SECTION: $4A20-$4AFF ($00E0 bytes) ["Enemies"]
+           $4A20 = UpdateEnemies
+WRAM0 bank #0:
+  SECTION: $C0A0-$C0A1 ($0002 bytes) ["Variables"]
+           $C0A0 = wPlayerX
+HRAM bank #0:
+  SECTION: $FF80-$FF80 ($0001 byte) ["HRAM"]
+           $FF80 = hFrameCounter
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLookups(t, s)
+}
+
+// Check the symbols in the files of TestReadSymbols and TestReadMap.
+func checkLookups(t *testing.T, s *Symbols) {
+	t.Helper()
+	tests := []struct {
+		bank    int
+		address uint16
+		name    string
+		found   bool
+	}{
+		{0, 0x0150, "Start", true},
+		{1, 0x4A20, "UpdatePlayer", true},
+		{2, 0x4A20, "UpdateEnemies", true},
+		{3, 0x4A20, "", false},
+		// Addresses outside the switchable bank have the same name whatever bank is switched in.
+		{5, 0xC0A0, "wPlayerX", true},
+		{1, 0xFF80, "hFrameCounter", true},
+		{0, 0x0151, "", false},
+	}
+	for _, test := range tests {
+		name, found := s.Lookup(test.bank, test.address)
+		if name != test.name || found != test.found {
+			t.Errorf("Lookup(%d, %04X) = %q, %t, want %q, %t", test.bank, test.address, name, found, test.name, test.found)
+		}
+	}
+}
+
+func TestReadSymbolsErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		err  string
+	}{
+		{"00:0150 Start\n0150 Main\n", "line 2: expected bank:address name"},
+		{"00:0150 Start Main\n", "line 1: expected bank:address name"},
+		{"zz:0150 Start\n", `line 1: invalid bank "zz"`},
+		{"00:10000 Start\n", `line 1: invalid address "10000"`},
+	}
+	for _, test := range tests {
+		_, err := ReadSymbols(strings.NewReader(test.file))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, want %q", test.file, err, test.err)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	s := NewSymbols()
+	s.Add(0, 0x0150, "Main")
+	s.Add(0, 0x0160, "Main.loop")
+	s.Add(1, 0x4000, "Bank1")
+	s.Add(0, 0xC000, "wVariables")
+	tests := []struct {
+		bank     int
+		address  uint16
+		describe string
+		function string
+	}{
+		{0, 0x0150, "Main", "Main"},
+		{0, 0x0155, "Main+5", "Main"},
+		{0, 0x0162, "Main.loop+2", "Main"},
+		{1, 0x4010, "Bank1+16", "Bank1"},
+		// Nothing before them in their part of the memory.
+		{2, 0x4010, "$4010", ""},
+		{0, 0x0100, "$0100", ""},
+		{0, 0x8000, "$8000", ""},
+		{0, 0xC001, "wVariables+1", "wVariables"},
+	}
+	for _, test := range tests {
+		if describe := s.Describe(test.bank, test.address); describe != test.describe {
+			t.Errorf("Describe(%d, %04X) = %q, want %q", test.bank, test.address, describe, test.describe)
+		}
+		if function, _ := s.Function(test.bank, test.address); function != test.function {
+			t.Errorf("Function(%d, %04X) = %q, want %q", test.bank, test.address, function, test.function)
+		}
+	}
+	var none *Symbols
+	if describe := none.Describe(0, 0x0150); describe != "$0150" {
+		t.Errorf("got %q without symbols", describe)
+	}
+}
+
+func TestFindSymbols(t *testing.T) {
+	dir := t.TempDir()
+	rom := filepath.Join(dir, "game.gb")
+	if s, err := FindSymbols(rom); s != nil || err != nil {
+		t.Fatalf("got %v, %v without a symbol file", s, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "game.map"), []byte("ROM0 bank #0:\n  $0150 = FromMap\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := FindSymbols(rom); err != nil || s.Describe(0, 0x0150) != "FromMap" {
+		t.Fatalf("the .map file wasn't loaded: %v", err)
+	}
+	// The .sym file comes first.
+	if err := os.WriteFile(filepath.Join(dir, "game.sym"), []byte("00:0150 FromSym\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := FindSymbols(rom); err != nil || s.Describe(0, 0x0150) != "FromSym" {
+		t.Fatalf("the .sym file wasn't loaded: %v", err)
+	}
+}
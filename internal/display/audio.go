@@ -0,0 +1,78 @@
+package display
+
+import (
+	"go-boy/internal/apu"
+	"go-boy/internal/sound"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Sample rate the sound is played at.
+const sampleRate = 48000
+
+// Audio plays the sound of the APU through ebiten. The samples go through a buffer of 100ms, which the
+// window keeps half full by running more or fewer frames, and by bending the APU's sample rate a little.
+type Audio struct {
+	apu    *apu.APU
+	buffer *sound.Buffer
+	player *audio.Player
+	volume float64
+	muted  bool
+	// Keep the sample rate as it is, without bending it to fill the buffer.
+	fixedRate bool
+}
+
+// NewAudio starts playing the sound of the APU with the given volume, from 0 to 1.
+// If the APU already has an output, like a WAV file, it keeps getting the samples, and the sample rate isn't bent
+// so that it gets exactly as many as it asked for. The pacing is then only done by skipping or repeating frames.
+func NewAudio(a *apu.APU, volume float64, muted bool) (*Audio, error) {
+	buffer := sound.NewBuffer(sampleRate / 10)
+	player, err := audio.NewContext(sampleRate).NewPlayer(buffer)
+	if err != nil {
+		return nil, err
+	}
+	au := &Audio{apu: a, buffer: buffer, player: player, volume: volume, muted: muted}
+	if a.Output != nil {
+		a.Output = sound.Tee{a.Output, buffer}
+		au.fixedRate = true
+	} else {
+		a.Output = buffer
+		a.SampleRate = sampleRate
+	}
+	au.updateVolume()
+	player.Play()
+	return au, nil
+}
+
+// Frames to run in this update so that the buffer doesn't run out or overflow.
+// Usually 1, but if the screen refreshes faster or slower than the Game Boy, a frame is skipped or run twice now and then.
+func (au *Audio) framesToRun() int {
+	fill := au.buffer.Fill()
+	if fill < 0.25 {
+		return 2
+	} else if fill > 0.75 {
+		return 0
+	}
+	return 1
+}
+
+// Called once per update. Adjusts the sample rate and handles the mute key (M).
+func (au *Audio) update() {
+	if !au.fixedRate {
+		au.apu.SampleRate = au.buffer.Rate(sampleRate)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		au.muted = !au.muted
+		au.updateVolume()
+	}
+}
+
+func (au *Audio) updateVolume() {
+	if au.muted {
+		au.player.SetVolume(0)
+	} else {
+		au.player.SetVolume(au.volume)
+	}
+}
@@ -0,0 +1,378 @@
+package display
+
+import (
+	"fmt"
+	"go-boy/internal/game"
+	"image/color"
+	"os"
+	"strconv"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+var gameFont font.Face
+
+// Smaller face of the same font for the sound overlay.
+var overlayFont font.Face
+
+// The 4 different colors in the Game Boy, from lighter to darker.
+var color00 = color.RGBA{0xE0, 0xF8, 0xCF, 0xFF}
+var color01 = color.RGBA{0x86, 0xC0, 0x6C, 0xFF}
+var color10 = color.RGBA{0x30, 0x68, 0x50, 0xFF}
+var color11 = color.RGBA{0x07, 0x18, 0x21, 0xFF}
+var colors = [4]color.RGBA{color00, color01, color10, color11}
+
+// Window shows a game on screen with ebiten.
+// Every update runs one frame of the game, and every draw prints what's in its memory.
+type Window struct {
+	Game *game.Game
+	// Sound output. Without it, the game runs one frame per update and there's no sound.
+	Audio *Audio
+	// With a debugger, the frames are run through it, and the game only changes while it's locked.
+	Debugger Debugger
+
+	// Holding this key rewinds the game, if it has a rewind buffer.
+	RewindKey ebiten.Key
+
+	// Save states are saved as StatePrefix-1.state to StatePrefix-10.state. F1 to F10 load them, and with shift they save them.
+	StatePrefix string
+
+	// What the sound channels are playing, drawn on top of the game. V shows and hides it.
+	overlay     *overlay
+	showOverlay bool
+
+	// Viewer of the video memory shown instead of the game, if any, and the palette of the tile viewer.
+	viewer  viewer
+	palette int
+}
+
+// Hotkeys returns the keys the window handles itself, given the one that rewinds, with what each of them does.
+// Binding them to the joypad too would make them do both things at once.
+func Hotkeys(rewindKey ebiten.Key) map[ebiten.Key]string {
+	hotkeys := map[ebiten.Key]string{
+		rewindKey:   "rewinding",
+		ebiten.KeyV: "the sound overlay",
+		ebiten.KeyM: "muting the sound",
+		ebiten.KeyP: "the palette of the tile viewer",
+	}
+	viewerNames := map[viewer]string{
+		tileViewer: "the tile viewer",
+		mapViewer:  "the tile map viewer",
+		oamViewer:  "the OAM viewer",
+	}
+	for key, v := range viewerKeys {
+		hotkeys[key] = viewerNames[v]
+	}
+	for i, key := range channelKeys {
+		hotkeys[key] = fmt.Sprintf("muting sound channel %d", i+1)
+	}
+	for i, key := range stateKeys {
+		hotkeys[key] = fmt.Sprintf("save state slot %d", i+1)
+	}
+	return hotkeys
+}
+
+// Debugger runs the frames instead of the window, so that it can pause the game whenever it wants.
+// It changes the game from elsewhere too, so the window locks it while it uses it.
+type Debugger interface {
+	Frame() error
+	Lock()
+	Unlock()
+}
+
+func init() {
+	fontFileBytes, err := os.ReadFile("assets/Hack-Regular.ttf")
+	if err != nil {
+		panic(err)
+	}
+	tt, _ := opentype.Parse(fontFileBytes)
+	gameFont, _ = opentype.NewFace(tt, &opentype.FaceOptions{
+		Size: 30,
+		DPI:  36,
+	})
+	overlayFont, _ = opentype.NewFace(tt, &opentype.FaceOptions{
+		Size: 8,
+		DPI:  72,
+	})
+}
+
+// Update function. Runs one frame of the game, or as many as the sound needs to keep playing smoothly.
+func (w *Window) Update() error {
+	if w.Debugger != nil {
+		w.Debugger.Lock()
+		defer w.Debugger.Unlock()
+	}
+	w.Game.Rewinding = w.Game.Rewind != nil && ebiten.IsKeyPressed(w.RewindKey)
+	frames := 1
+	if w.Audio != nil {
+		w.Audio.update()
+		// While rewinding there's no sound to keep up with, so it just steps back once per update.
+		if !w.Game.Rewinding {
+			frames = w.Audio.framesToRun()
+		}
+	}
+	updateChannelKeys(w.Game.APU)
+	w.updateStateKeys()
+	if inpututil.IsKeyJustPressed(ebiten.KeyV) {
+		w.showOverlay = !w.showOverlay
+		// The samples are only kept once the overlay has been shown.
+		if w.overlay == nil {
+			w.overlay = newOverlay(w.Game.APU)
+		}
+	}
+	w.updateViewerKeys()
+	for i := 0; i < frames; i++ {
+		update := w.Game.Update
+		if w.Debugger != nil {
+			update = w.Debugger.Frame
+		}
+		if err := update(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Draw function. Prints the tiles and sprites, but does not execute instructions.
+func (w *Window) Draw(screen *ebiten.Image) {
+	if w.Debugger != nil {
+		w.Debugger.Lock()
+		defer w.Debugger.Unlock()
+	}
+	if w.viewer != noViewer {
+		w.drawViewer(screen)
+		return
+	}
+
+	// Fill the whole screen with gray, so that looking at it doesn't hurt our eyes.
+	screen.Fill(color.Gray{0x77})
+
+	// Take the LCD controller data
+	lcdc := w.Game.M.Read(0xFF40)
+	// scx := w.Game.M.Read(0xFF42)
+	// scy := w.Game.M.Read(0xFF43)
+
+	// Display background and window?
+	if lcdc&0x01 == 1 {
+		// Draw the background
+		w.drawBackground(screen, lcdc)
+
+		// Display sprites?
+		if lcdc&0x02 != 0 {
+			//Draw sprites
+			w.drawSprites(screen, lcdc)
+		}
+	}
+	// w.debugMemory(screen)
+	if w.showOverlay {
+		w.overlay.draw(screen)
+	}
+}
+
+// Method to draw the background of the game.
+func (w *Window) drawBackground(screen *ebiten.Image, lcdc byte) {
+	var tileMapAddr, tileDataAddr uint16
+	var signed bool
+
+	// The colour palette for the background. Each 2 bits indicate one of the 4 colours defined above.
+	bgp := w.Game.M.Read(0xFF47)
+	bgpColors := [4]color.RGBA{
+		colors[bgp&0x03],
+		colors[bgp&0x0C>>2],
+		colors[bgp&0x30>>4],
+		colors[bgp>>6],
+	}
+
+	// 4th bit of LCDC indicates whether the tile map for the background starts at 0x9800 or 0x9C00.
+	if lcdc&0x08 == 0 {
+		tileMapAddr = 0x9800
+	} else {
+		tileMapAddr = 0x9C00
+	}
+
+	// 5th bit of LCDC indicates whether the 0 address of the tiles data (the actual graphic data,
+	// not their disposition on the screen) is 0x8000 or 0x9000.
+	if lcdc&0x10 == 0 {
+		signed = true
+		tileDataAddr = 0x9000
+	} else {
+		signed = false
+		tileDataAddr = 0x8000
+	}
+
+	// GB screen is 20x18 tiles.
+	for y := 0; y < 18; y++ {
+		for x := 0; x < 20; x++ {
+			// Get the number of the current tile to be drawn.
+			tileNumber := w.Game.M.Read(tileMapAddr)
+			// Adjust with the data address
+			tileAddr := tileDataAddr + uint16(tileNumber)*16
+			// Haha funny. So apparently if the 0 address of the data is 0x8000, the tile number is unsigned,
+			// but if it's 0x9000, then it is signed and ranges from -126 to 127 and we need to adjust that too.
+			if signed && tileNumber >= 0x7F {
+				tileAddr = (0x0800 + uint16(tileNumber)) << 4
+			}
+
+			for i := 0; i < 8; i++ {
+				// To print the tile, we need to read 16 bytes in groups of 2.
+				// Every 2 bytes represent one line of 8 pixels in the tile. The way this works is:
+				// The first tile represents the LSB of the 8 pixels, the second represents the MSB.
+				// Group each LSB with its respective MSB and you'll get a list of numbers from 0 to 3,
+				// representing one of the 4 colours to be printed in that spot. Now to the code:
+
+				// Read the two lines.
+				tileLineLSB := w.Game.M.Read(tileAddr)
+				tileAddr++
+				tileLineMSB := w.Game.M.Read(tileAddr)
+				tileAddr++
+
+				// Transform them to binary.
+				binaryTileLineLSB := fmt.Sprintf("%08b", tileLineLSB)
+				binaryTileLineMSB := fmt.Sprintf("%08b", tileLineMSB)
+
+				for j := 0; j < 8; j++ {
+					// Pair the LSB with the MSB, parse as binary, select the color and print the pixel.
+					pair := string(binaryTileLineMSB[j]) + string(binaryTileLineLSB[j])
+					bgColor, _ := strconv.ParseInt(pair, 2, 8)
+					pixelColor := bgpColors[bgColor]
+					screen.Set(8*x+j, 8*y+i, pixelColor)
+				}
+			}
+			// Next tile.
+			tileMapAddr++
+		}
+		// We've ended a line. Jump the next 12 tiles, since they're outside the visible screen.
+		tileMapAddr += 12
+	}
+}
+
+func (w *Window) drawWindow(screen *ebiten.Image, lcdc byte) {
+
+}
+
+// Method to draw the sprites.
+func (w *Window) drawSprites(screen *ebiten.Image, lcdc byte) {
+	var height int
+
+	// Sprites can be coloured with two different palettes, OBP0 and OBP1.
+	obp0 := w.Game.M.Read(0xFF48)
+	obp1 := w.Game.M.Read(0xFF49)
+	obps := [2][4]color.RGBA{
+		{
+			colors[obp0&0x03],
+			colors[obp0&0x0C>>2],
+			colors[obp0&0x30>>4],
+			colors[obp0>>6],
+		},
+		{
+			colors[obp1&0x03],
+			colors[obp1&0x0C>>2],
+			colors[obp1&0x30>>4],
+			colors[obp1>>6],
+		},
+	}
+
+	// Sprites can also be 8x8 (height == 1), or 8x16 (height == 2).
+	if lcdc&0x04 == 0 {
+		height = 1
+	} else {
+		height = 2
+	}
+
+	// Draw sprites from end to start, because the ones in the start have more priority and should be drawn above the others.
+	var finalSpriteAddr uint16 = 0xFE9C
+
+	for nSprite := uint16(0); nSprite < 40; nSprite++ {
+		// Sprites have 4 bytes of data:
+		// Byte 0: Y position on the screen.
+		// Byte 1: X position on the screen.
+		// Byte 2: number of pattern in the tile map (sprites always start at 0x8000)
+		// Byte 3: priority, flip, and palette flags.
+		yPosition := int(w.Game.M.Read(finalSpriteAddr - 4*nSprite))
+		xPosition := int(w.Game.M.Read(finalSpriteAddr - 4*nSprite + 1))
+		patternNumber := w.Game.M.Read(finalSpriteAddr - 4*nSprite + 2)
+		if height == 2 {
+			patternNumber &= 0xFE
+		}
+		tileAddr := 0x8000 + uint16(patternNumber)*16
+		flags := w.Game.M.Read(finalSpriteAddr - 4*nSprite + 3)
+		priority := flags&0x80 == 0
+		yFlip := flags&0x40 != 0
+		xFlip := flags&0x20 != 0
+		obp := obps[flags&0x10>>4]
+
+		// The way to draw a sprite is almost the same as to draw the background.
+		// However, we need to do a few more checks.
+		for i := 0; i < 8*height; i++ {
+			tileLineLSB := w.Game.M.Read(tileAddr)
+			tileAddr++
+			tileLineMSB := w.Game.M.Read(tileAddr)
+			tileAddr++
+
+			binaryTileLineLSB := fmt.Sprintf("%08b", tileLineLSB)
+			binaryTileLineMSB := fmt.Sprintf("%08b", tileLineMSB)
+
+			for j := 0; j < 8; j++ {
+				var screenXPosition, screenYPosition int
+
+				// If flip flags are set, the sprites need to be drawn starting in the opposite side of the axis.
+				if !xFlip {
+					screenXPosition = xPosition + j - 8
+				} else {
+					screenXPosition = xPosition - j
+				}
+				if !yFlip {
+					screenYPosition = yPosition + i - 16
+				} else {
+					screenYPosition = yPosition - i
+				}
+
+				// Check that the pixel is on screen and should be drawn.
+				if screenXPosition >= 0 && screenXPosition < 160 && screenYPosition >= 0 && screenYPosition < 144 {
+					adjustedXPosition := screenXPosition
+					adjustedYPosition := screenYPosition
+					// Check that the sprite either has priority or is on a 00 pixel.
+					// Sprites should not be drawn on top of the background unless one of these two conditions is true.
+					if priority || screen.At(adjustedXPosition, adjustedYPosition) == color00 {
+						pair := string(binaryTileLineMSB[j]) + string(binaryTileLineLSB[j])
+						obColor, _ := strconv.ParseInt(pair, 2, 8)
+						// if the color of the pixel is 0, it is considered transparent and should not replace the background.
+						if obColor != 0 {
+							pixelColor := obp[obColor]
+							screen.Set(adjustedXPosition, adjustedYPosition, pixelColor)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Method to print the contents of a part of the memory. Only for debugging
+func (w *Window) debugMemory(screen *ebiten.Image) {
+	bytesToWrite := ""
+	// First address to print.
+	current := 0xFF00
+	// Print until we reach the specified address.
+	for current <= 0xFF00 {
+		endLine := current + 0x0F
+		for current <= endLine {
+			bytesToWrite += fmt.Sprintf("%02X ", w.Game.M.Read(uint16(current)))
+			current++
+		}
+		bytesToWrite += "\n"
+	}
+
+	ebitenutil.DebugPrint(screen, bytesToWrite)
+}
+
+func (w *Window) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+	if size, ok := viewerSizes[w.viewer]; ok {
+		return size[0], size[1]
+	}
+	return outsideWidth / 4, outsideHeight / 4
+}
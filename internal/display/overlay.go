@@ -0,0 +1,132 @@
+package display
+
+import (
+	"fmt"
+	"go-boy/internal/apu"
+	"go-boy/internal/sound"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// Samples kept of each channel for the oscilloscope, and how many of them are drawn.
+// The trace starts where the wave goes up through its middle, somewhere in the older half, so that it stands still.
+const (
+	scopeSamples = 640
+	scopeShown   = 320
+)
+
+// Height of each channel's row in the overlay, and of its oscilloscope.
+const (
+	rowHeight   = 36
+	scopeHeight = 22
+)
+
+var overlayBackground = color.RGBA{0x00, 0x00, 0x00, 0xC0}
+var overlayText = color.RGBA{0xE0, 0xF8, 0xCF, 0xFF}
+var overlayMuted = color.RGBA{0x60, 0x60, 0x60, 0xFF}
+
+// The 4 duty cycles of the square channels.
+var dutyNames = [4]string{"12.5%", "25%", "50%", "75%"}
+
+// Volume of the wave channel, by the code in NR32.
+var waveVolumes = [4]string{"0%", "100%", "50%", "25%"}
+
+// Hotkeys to mute each channel. With shift, they solo it instead.
+var channelKeys = [4]ebiten.Key{ebiten.Key1, ebiten.Key2, ebiten.Key3, ebiten.Key4}
+
+// Overlay shows what each sound channel is playing: its frequency, volume and duty, and an oscilloscope of its output.
+type overlay struct {
+	apu     *apu.APU
+	samples [4][scopeSamples]float32
+	next    int // Where the next sample goes.
+}
+
+// Start keeping the samples of the APU's channels, along with whatever else gets them.
+func newOverlay(a *apu.APU) *overlay {
+	o := &overlay{apu: a}
+	if a.Output == nil {
+		a.Output = o
+	} else {
+		a.Output = sound.Tee{a.Output, o}
+	}
+	return o
+}
+
+func (o *overlay) WriteSample(s apu.Sample) {
+	for i := range o.samples {
+		o.samples[i][o.next] = s.Channels[i]
+	}
+	o.next = (o.next + 1) % scopeSamples
+}
+
+// Mute or solo channels with the number keys.
+func updateChannelKeys(a *apu.APU) {
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+	for i, key := range channelKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		if shift {
+			a.Solo(i)
+		} else {
+			a.ToggleMute(i)
+		}
+	}
+}
+
+func (o *overlay) draw(screen *ebiten.Image) {
+	ebitenutil.DrawRect(screen, 0, 0, 160, 144, overlayBackground)
+	lines := o.channelLines()
+	for i := range lines {
+		clr := overlayText
+		if o.apu.Muted[i] {
+			clr = overlayMuted
+		}
+		y := i * rowHeight
+		text.Draw(screen, lines[i], overlayFont, 2, y+9, clr)
+		o.drawScope(screen, i, y+rowHeight-scopeHeight-2, clr)
+	}
+}
+
+// One line of text for each channel.
+func (o *overlay) channelLines() [4]string {
+	c1, c2, c3, c4 := o.apu.Channel1, o.apu.Channel2, o.apu.Channel3, o.apu.Channel4
+	var lines [4]string
+	for i, c := range []*apu.Square{c1, c2} {
+		lines[i] = fmt.Sprintf("%d %s %7.1fHz v%-2d %s", i+1, onOff(c.Enabled), c.Hz(), c.Volume(), dutyNames[c.Duty])
+	}
+	lines[2] = fmt.Sprintf("3 %s %7.1fHz %s", onOff(c3.Enabled), c3.Hz(), waveVolumes[c3.VolumeCode])
+	width := "15bit"
+	if c4.ShortMode {
+		width = "7bit"
+	}
+	lines[3] = fmt.Sprintf("4 %s %7.0fHz v%-2d %s", onOff(c4.Enabled), c4.Hz(), c4.Volume(), width)
+	return lines
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on "
+	}
+	return "off"
+}
+
+// Draw the last samples of a channel, 2 per pixel, from -1 at the bottom to 1 at the top.
+func (o *overlay) drawScope(screen *ebiten.Image, channel int, top int, clr color.Color) {
+	// Put the samples in order, from the oldest to the newest.
+	var ordered [scopeSamples]float32
+	copy(ordered[:], o.samples[channel][o.next:])
+	copy(ordered[scopeSamples-o.next:], o.samples[channel][:o.next])
+
+	start := sound.TriggerPoint(ordered[:], scopeShown)
+	y := func(v float32) float64 {
+		return float64(top) + float64(1-v)/2*scopeHeight
+	}
+	for x := 1; x < scopeShown/2; x++ {
+		ebitenutil.DrawLine(screen, float64(x-1), y(ordered[start+2*x-2]), float64(x), y(ordered[start+2*x]), clr)
+	}
+}
@@ -0,0 +1,42 @@
+package display
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Hotkeys of the 10 save state slots.
+var stateKeys = [10]ebiten.Key{
+	ebiten.KeyF1, ebiten.KeyF2, ebiten.KeyF3, ebiten.KeyF4, ebiten.KeyF5,
+	ebiten.KeyF6, ebiten.KeyF7, ebiten.KeyF8, ebiten.KeyF9, ebiten.KeyF10,
+}
+
+// Save or load states with the function keys. It's done between frames, so they're always whole.
+func (w *Window) updateStateKeys() {
+	if w.StatePrefix == "" {
+		return
+	}
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+	for i, key := range stateKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		filename := fmt.Sprintf("%s-%d.state", w.StatePrefix, i+1)
+		if shift {
+			if err := w.Game.SaveStateFile(filename); err != nil {
+				fmt.Fprintln(os.Stderr, "saving state:", err)
+			} else {
+				fmt.Fprintln(os.Stderr, "saved state", i+1)
+			}
+		} else {
+			if err := w.Game.LoadStateFile(filename); err != nil {
+				fmt.Fprintln(os.Stderr, "loading state:", err)
+			} else {
+				fmt.Fprintln(os.Stderr, "loaded state", i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package display
+
+import (
+	"fmt"
+	"go-boy/internal/vram"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// Viewers of the video memory, shown instead of the game while it keeps running.
+// They only read the memory, so showing them doesn't change anything in the game.
+type viewer int
+
+const (
+	noViewer viewer = iota
+	tileViewer
+	mapViewer
+	oamViewer
+)
+
+// Hotkeys that show each viewer, and hide it when pressed again.
+var viewerKeys = map[ebiten.Key]viewer{
+	ebiten.KeyT: tileViewer,
+	ebiten.KeyB: mapViewer,
+	ebiten.KeyO: oamViewer,
+}
+
+// Palettes the tile viewer can draw the tiles with. P switches between them.
+var viewerPalettes = [4]string{"BGP", "OBP0", "OBP1", "none"}
+
+// Size of each viewer's screen. The window keeps its size, so they're scaled to fit it.
+var viewerSizes = map[viewer][2]int{
+	tileViewer: {160, 240},
+	mapViewer:  {528, 288},
+	oamViewer:  {320, 360},
+}
+
+var viewportColor = color.RGBA{0xFF, 0x30, 0x30, 0xFF}
+var gridColor = color.RGBA{0x40, 0x40, 0x40, 0xFF}
+
+// Show and hide the viewers with their keys, and change the tile viewer's palette.
+func (w *Window) updateViewerKeys() {
+	for key, v := range viewerKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		if w.viewer == v {
+			w.viewer = noViewer
+		} else {
+			w.viewer = v
+		}
+	}
+	if w.viewer == tileViewer && inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		w.palette = (w.palette + 1) % len(viewerPalettes)
+	}
+}
+
+func (w *Window) drawViewer(screen *ebiten.Image) {
+	screen.Fill(color.Black)
+	switch w.viewer {
+	case tileViewer:
+		w.drawTiles(screen)
+	case mapViewer:
+		w.drawMaps(screen)
+	case oamViewer:
+		w.drawOAM(screen)
+	}
+}
+
+// The shades of a palette register, or the shades in order without one.
+func (w *Window) paletteShades(register uint16) [4]byte {
+	if register == 0 {
+		return vram.NoPalette
+	}
+	return vram.Shades(w.Game.M.Read(register))
+}
+
+// Pixels of an image being put together before it's drawn, since setting them one by one on ebiten's images is slow.
+type pixels struct {
+	width, height int
+	rgba          []byte
+}
+
+func newPixels(width, height int) *pixels {
+	return &pixels{width, height, make([]byte, 4*width*height)}
+}
+
+func (p *pixels) set(x, y int, c color.RGBA) {
+	i := 4 * (y*p.width + x)
+	p.rgba[i], p.rgba[i+1], p.rgba[i+2], p.rgba[i+3] = c.R, c.G, c.B, c.A
+}
+
+// Draw the tile at the given offset of VRAM, 8 by 8, with its top left corner at x, y.
+func (p *pixels) tile(w *Window, offset, x, y int, shades [4]byte) {
+	for ty, row := range vram.Tile(w.Game.M.VRAM, offset, shades) {
+		for tx, shade := range row {
+			p.set(x+tx, y+ty, colors[shade])
+		}
+	}
+}
+
+func (p *pixels) drawAt(screen *ebiten.Image, x, y float64) {
+	image := ebiten.NewImage(p.width, p.height)
+	image.ReplacePixels(p.rgba)
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(x, y)
+	screen.DrawImage(image, op)
+	image.Dispose()
+}
+
+// All 384 tiles in VRAM, 16 per row with a line between them, from 8000 to 97FF.
+func (w *Window) drawTiles(screen *ebiten.Image) {
+	registers := [4]uint16{0xFF47, 0xFF48, 0xFF49, 0}
+	palette := w.paletteShades(registers[w.palette])
+	const columns, rows = 16, 24
+	p := newPixels(columns*9+1, rows*9+1)
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			p.set(x, y, gridColor)
+		}
+	}
+	for n := 0; n < columns*rows; n++ {
+		p.tile(w, 16*n, 1+n%columns*9, 1+n/columns*9, palette)
+	}
+	p.drawAt(screen, 7, 14)
+	text.Draw(screen, fmt.Sprintf("Tiles, palette %s (P)", viewerPalettes[w.palette]), overlayFont, 2, 10, overlayText)
+}
+
+// Both 32x32 tile maps, at 9800 and 9C00, with the tiles addressed as the LCDC says and the background palette.
+// The rectangle is the part of the background on screen, which wraps around the edges.
+func (w *Window) drawMaps(screen *ebiten.Image) {
+	lcdc := w.Game.M.Read(0xFF40)
+	scy, scx := int(w.Game.M.Read(0xFF42)), int(w.Game.M.Read(0xFF43))
+	palette := w.paletteShades(0xFF47)
+	for i, base := range [2]int{0x1800, 0x1C00} {
+		p := newPixels(256, 256)
+		for n := 0; n < 32*32; n++ {
+			p.tile(w, vram.MapTileOffset(w.Game.M.VRAM[base+n], lcdc), n%32*8, n/32*8, palette)
+		}
+		// LCDC bit 3 tells which map is the background.
+		if lcdc&0x08 != 0 == (i == 1) {
+			for _, point := range vram.Viewport(scx, scy) {
+				p.set(point.X, point.Y, viewportColor)
+			}
+		}
+		x := 4 + i*264
+		p.drawAt(screen, float64(x), 16)
+		label := fmt.Sprintf("%04X", 0x8000+base)
+		if lcdc&0x08 != 0 == (i == 1) {
+			label += fmt.Sprintf("  background, SCX %d SCY %d", scx, scy)
+		}
+		if lcdc&0x40 != 0 == (i == 1) && lcdc&0x20 != 0 {
+			label += fmt.Sprintf("  window, WX %d WY %d", w.Game.M.Read(0xFF4B), w.Game.M.Read(0xFF4A))
+		}
+		text.Draw(screen, label, overlayFont, x, 11, overlayText)
+	}
+}
+
+// A row for each of the 40 sprites in OAM, with its tile, position, tile number and attributes, in two columns.
+func (w *Window) drawOAM(screen *ebiten.Image) {
+	tall := w.Game.M.Read(0xFF40)&0x04 != 0
+	palettes := [2][4]byte{w.paletteShades(0xFF48), w.paletteShades(0xFF49)}
+	const rowHeight = 17
+	// The tiles of all the sprites go in the same image, and the text on top.
+	p := newPixels(320, 360)
+	for column := 0; column < 2; column++ {
+		x := column * 160
+		text.Draw(screen, "#    Y   X  tile flags", overlayFont, x+14, 10, overlayText)
+	}
+	for n, sprite := range vram.Sprites(w.Game.M.OAM, tall) {
+		left, top := n/20*160, 14+n%20*rowHeight
+		// The tile, not flipped. 8x16 sprites are two tiles, one under the other.
+		tiles := 1
+		if tall {
+			tiles = 2
+		}
+		for i := 0; i < tiles; i++ {
+			p.tile(w, 16*(int(sprite.Tile)+i), left+2, top+8*i, palettes[sprite.Palette()])
+		}
+		text.Draw(screen, sprite.Row(n), overlayFont, left+14, top+9, overlayText)
+	}
+	p.drawAt(screen, 0, 0)
+}
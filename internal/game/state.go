@@ -0,0 +1,126 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go-boy/internal/joypad"
+	"go-boy/internal/state"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Save states start with a header of:
+//
+//	"GBST" | version (2 bytes) | CRC32 of the ROM (4 bytes)
+//
+// followed by the frame, the registers, the memory, the GPU, the timers, the APU, the serial port and the position
+// of the movie being played (-1 if none), in that order.
+// The version has to change whenever anything is added to them or changes order.
+const (
+	stateMagic   = "GBST"
+	stateVersion = uint16(3)
+)
+
+// SaveState writes the state of the whole machine, so that LoadState brings it back exactly as it is now.
+func (g *Game) SaveState(w io.Writer) error {
+	e := state.NewEncoder(w)
+	e.Write([]byte(stateMagic), stateVersion, crc32.ChecksumIEEE(g.M.Cartridge))
+	e.Write(g.Frame)
+	g.R.SaveState(e)
+	g.M.SaveState(e)
+	g.GPU.SaveState(e)
+	e.Write(g.frameCycles, g.divCycles, g.timaCycles)
+	g.APU.SaveState(e)
+	g.Serial.SaveState(e)
+	position := -1
+	if seeker, ok := g.Input.(joypad.Seeker); ok {
+		position = seeker.Position()
+	}
+	e.Write(position)
+	return e.Err()
+}
+
+// LoadState restores a state written by SaveState. It has to be from the same game and version.
+// If it fails, the machine is left as it was.
+func (g *Game) LoadState(r io.Reader) error {
+	if g.Recording {
+		return fmt.Errorf("can't load a state while recording a movie")
+	}
+	// Keep the current state around, in case the new one turns out to be broken halfway through.
+	var previous bytes.Buffer
+	if err := g.SaveState(&previous); err != nil {
+		return err
+	}
+	if err := g.loadState(r); err != nil {
+		if restoreErr := g.loadState(&previous); restoreErr != nil {
+			panic(restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (g *Game) loadState(r io.Reader) error {
+	d := state.NewDecoder(r)
+	magic := make([]byte, len(stateMagic))
+	var version uint16
+	var checksum uint32
+	d.Read(magic, &version, &checksum)
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("not a save state: %v", err)
+	}
+	if string(magic) != stateMagic {
+		return fmt.Errorf("not a save state")
+	}
+	if version != stateVersion {
+		return fmt.Errorf("unsupported save state version %d", version)
+	}
+	if checksum != crc32.ChecksumIEEE(g.M.Cartridge) {
+		return fmt.Errorf("the save state is from a different game")
+	}
+	d.Read(&g.Frame)
+	g.R.LoadState(d)
+	g.M.LoadState(d)
+	g.GPU.LoadState(d)
+	d.Read(&g.frameCycles, &g.divCycles, &g.timaCycles)
+	g.APU.LoadState(d)
+	g.Serial.LoadState(d)
+	var position int
+	d.Read(&position)
+	if seeker, ok := g.Input.(joypad.Seeker); ok && position >= 0 && d.Err() == nil {
+		seeker.Seek(position)
+	}
+	// The calls that led here aren't in the state.
+	if g.Calls != nil {
+		g.Calls.Reset()
+	}
+	return d.Err()
+}
+
+// SaveStateFile saves the state of the machine into a file.
+func (g *Game) SaveStateFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if err = g.SaveState(w); err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadStateFile loads a state saved with SaveStateFile.
+func (g *Game) LoadStateFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return g.LoadState(bufio.NewReader(file))
+}
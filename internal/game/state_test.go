@@ -0,0 +1,153 @@
+package game
+
+import (
+	"bytes"
+	"go-boy/internal/joypad"
+	"testing"
+)
+
+// A game with the given code at 0100, and nothing else in its ROM.
+func newGame(code ...byte) *Game {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], code)
+	return New(bytes.NewReader(rom))
+}
+
+// LD HL,$C000; INC (HL); JR -3
+var counter = []byte{0x21, 0x00, 0xC0, 0x34, 0x18, 0xFD}
+
+func save(t *testing.T, g *Game) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := g.SaveState(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func run(t *testing.T, g *Game, frames int) {
+	t.Helper()
+	for i := 0; i < frames; i++ {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	g := newGame(counter...)
+	run(t, g, 3)
+	before := save(t, g)
+	run(t, g, 5)
+	after := save(t, g)
+	if bytes.Equal(before, after) {
+		t.Fatal("the state didn't change in 5 frames")
+	}
+
+	if err := g.LoadState(bytes.NewReader(before)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(save(t, g), before) {
+		t.Error("the state loaded isn't the one saved")
+	}
+	if g.Frame != 3 {
+		t.Errorf("at frame %d after loading, want 3", g.Frame)
+	}
+	// From the same state, the same frames are run.
+	run(t, g, 5)
+	if !bytes.Equal(save(t, g), after) {
+		t.Error("running from a loaded state ends somewhere else")
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	g := newGame(counter...)
+	run(t, g, 2)
+	good := save(t, g)
+	other := newGame(append(counter, 0xFF)...)
+	otherGame := save(t, other)
+	changed := func(offset int, b byte) []byte {
+		data := append([]byte{}, good...)
+		data[offset] = b
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a state", changed(0, 'X')},
+		{"another version", changed(4, byte(stateVersion+1))},
+		{"another game", otherGame},
+		{"cut short", good[:len(good)-3]},
+		{"cut halfway", good[:len(good)/2]},
+	}
+	run(t, g, 1)
+	current := save(t, g)
+	for _, test := range tests {
+		if err := g.LoadState(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: loaded without errors", test.name)
+		}
+		if !bytes.Equal(save(t, g), current) {
+			t.Errorf("%s: the game changed after failing to load", test.name)
+		}
+	}
+}
+
+// A movie being played back.
+type seeker struct {
+	position int
+}
+
+func (s *seeker) Poll() joypad.State {
+	s.position++
+	return 0
+}
+
+func (s *seeker) Position() int {
+	return s.position
+}
+
+func (s *seeker) Seek(frame int) {
+	s.position = frame
+}
+
+func TestSaveStateMoviePosition(t *testing.T) {
+	g := newGame(counter...)
+	s := &seeker{}
+	g.Input = s
+	run(t, g, 4)
+	state := save(t, g)
+	run(t, g, 3)
+	if err := g.LoadState(bytes.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+	if s.position != 4 {
+		t.Errorf("movie at frame %d after loading, want 4", s.position)
+	}
+
+	// States saved without a movie leave it where it is.
+	g.Input = nil
+	state = save(t, g)
+	g.Input = s
+	s.position = 9
+	if err := g.LoadState(bytes.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+	if s.position != 9 {
+		t.Errorf("movie moved to frame %d by a state without one", s.position)
+	}
+}
+
+func TestLoadStateWhileRecording(t *testing.T) {
+	g := newGame(counter...)
+	state := save(t, g)
+	run(t, g, 1)
+	g.Recording = true
+	if err := g.LoadState(bytes.NewReader(state)); err == nil {
+		t.Error("loaded a state while recording a movie")
+	}
+	if g.Frame != 1 {
+		t.Errorf("at frame %d, want 1", g.Frame)
+	}
+}
@@ -0,0 +1,466 @@
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-boy/internal/game"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrKilled is returned by Frame once GDB has killed the game, so that the window closes.
+var ErrKilled = errors.New("killed from GDB")
+
+// The registers are sent in the order of GDB's z80 target, all of them 16 bits long:
+// AF, BC, DE, HL, SP, PC, and then IX, IY, AF', BC', DE', HL' and IR, which the Game Boy doesn't have and are always 0.
+const registerCount = 13
+
+// Signals sent in the stop replies.
+const (
+	sigint  = 2
+	sigill  = 4
+	sigtrap = 5
+)
+
+// Stub lets GDB, or anything else that speaks its remote serial protocol, debug the game over a connection.
+// Headless, it runs the game itself. With a window, the window runs the frames through Frame while the game isn't
+// paused, and the packets are handled in between.
+type Stub struct {
+	Game *game.Game
+
+	mu          sync.Mutex
+	conn        io.ReadWriter
+	packets     chan packet
+	stopped     chan string // Stop replies from Frame, when it pauses the game.
+	paused      bool
+	killed      bool
+	detached    bool // GDB is gone, so the game runs on its own.
+	noAck       bool
+	breakpoints map[uint16]bool
+	watchpoints []watchpoint
+	watchHit    string // Stop reply for the watchpoint hit by the last instruction.
+}
+
+// A packet from GDB, or the interrupt byte it sends to pause the game while it runs.
+type packet struct {
+	data      string
+	interrupt bool
+}
+
+// Listen waits for GDB to connect at the given address, and returns a stub for the game with the game paused.
+func Listen(address string, g *game.Game) (*Stub, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, g), nil
+}
+
+// New returns a stub that talks to GDB through conn, with the game paused.
+func New(conn io.ReadWriter, g *game.Game) *Stub {
+	s := &Stub{
+		Game:        g,
+		conn:        conn,
+		packets:     make(chan packet),
+		stopped:     make(chan string, 1),
+		paused:      true,
+		breakpoints: make(map[uint16]bool),
+	}
+	go s.readPackets()
+	return s
+}
+
+// Lock keeps the game from changing, for example while it's drawn.
+func (s *Stub) Lock() {
+	s.mu.Lock()
+}
+
+func (s *Stub) Unlock() {
+	s.mu.Unlock()
+}
+
+// Frame runs one frame of the game unless it's paused, stopping halfway at breakpoints and watchpoints.
+// It's what the window calls instead of Game.Update, with the lock held. Once GDB is gone, it's just Game.Update.
+func (s *Stub) Frame() error {
+	if s.killed {
+		return ErrKilled
+	}
+	if s.detached {
+		return s.Game.Update()
+	}
+	if s.paused {
+		return nil
+	}
+	for {
+		frameOver, stop := s.step(false)
+		if stop != "" {
+			s.paused = true
+			s.stopped <- stop
+			return nil
+		}
+		if frameOver {
+			return nil
+		}
+	}
+}
+
+// Run handles GDB's packets until it kills the game, detaches or disconnects.
+// windowed tells whether a window runs the frames through Frame, in which case the game carries on on its own
+// afterwards, or they have to be run here.
+func (s *Stub) Run(windowed bool) {
+	defer func() {
+		s.mu.Lock()
+		// Without GDB there's nothing to pause the game any more, nor to tell when it stops.
+		s.paused = false
+		s.detached = true
+		s.breakpoints = make(map[uint16]bool)
+		s.watchpoints = nil
+		s.Game.Watch = nil
+		s.mu.Unlock()
+	}()
+	for p := range s.packets {
+		if p.interrupt {
+			continue
+		}
+		switch {
+		case p.data == "k":
+			s.mu.Lock()
+			s.killed = true
+			s.mu.Unlock()
+			return
+		case p.data == "D" || strings.HasPrefix(p.data, "D;"):
+			s.send("OK")
+			return
+		case strings.HasPrefix(p.data, "c"):
+			if !s.resumeAt(p.data[1:]) {
+				continue
+			}
+			if windowed {
+				s.send(s.resume())
+			} else {
+				s.send(s.run(false))
+			}
+		case strings.HasPrefix(p.data, "s"):
+			if s.resumeAt(p.data[1:]) {
+				s.send(s.run(true))
+			}
+		default:
+			s.mu.Lock()
+			reply := s.handle(p.data)
+			s.mu.Unlock()
+			s.send(reply)
+		}
+	}
+}
+
+// Handle a packet that doesn't resume the game, returning the reply. It has to be called with the lock held.
+func (s *Stub) handle(data string) string {
+	switch {
+	case data == "?":
+		return fmt.Sprintf("S%02x", sigtrap)
+	case strings.HasPrefix(data, "qSupported"):
+		return "PacketSize=4000;QStartNoAckMode+"
+	case data == "QStartNoAckMode":
+		s.noAck = true
+		return "OK"
+	case data == "qAttached":
+		return "1"
+	case data == "qC":
+		return "QC1"
+	case data == "qfThreadInfo":
+		return "m1"
+	case data == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(data, "H"), strings.HasPrefix(data, "T"):
+		return "OK"
+	case data == "g":
+		var b strings.Builder
+		for i := 0; i < registerCount; i++ {
+			value := s.register(i)
+			fmt.Fprintf(&b, "%02x%02x", byte(value), byte(value>>8))
+		}
+		return b.String()
+	case strings.HasPrefix(data, "G"):
+		values, err := hex.DecodeString(data[1:])
+		if err != nil || len(values) < 12 {
+			return "E01"
+		}
+		for i := 0; i < 6; i++ {
+			s.setRegister(i, uint16(values[2*i])|uint16(values[2*i+1])<<8)
+		}
+		return "OK"
+	case strings.HasPrefix(data, "p"):
+		n, err := strconv.ParseUint(data[1:], 16, 8)
+		if err != nil || n >= registerCount {
+			return "E01"
+		}
+		value := s.register(int(n))
+		return fmt.Sprintf("%02x%02x", byte(value), byte(value>>8))
+	case strings.HasPrefix(data, "P"):
+		fields := strings.SplitN(data[1:], "=", 2)
+		n, err := strconv.ParseUint(fields[0], 16, 8)
+		if err != nil || len(fields) != 2 || n >= registerCount {
+			return "E01"
+		}
+		value, err := hex.DecodeString(fields[1])
+		if err != nil || len(value) != 2 {
+			return "E01"
+		}
+		s.setRegister(int(n), uint16(value[0])|uint16(value[1])<<8)
+		return "OK"
+	case strings.HasPrefix(data, "m"):
+		address, length, ok := parseRange(data[1:])
+		if !ok {
+			return "E01"
+		}
+		var b strings.Builder
+		for i := 0; i < length; i++ {
+			fmt.Fprintf(&b, "%02x", s.Game.M.Read(uint16(address+i)))
+		}
+		return b.String()
+	case strings.HasPrefix(data, "M"):
+		fields := strings.SplitN(data[1:], ":", 2)
+		address, length, ok := parseRange(fields[0])
+		if !ok || len(fields) != 2 {
+			return "E01"
+		}
+		values, err := hex.DecodeString(fields[1])
+		if err != nil || len(values) != length {
+			return "E01"
+		}
+		for i, value := range values {
+			s.Game.M.Store(uint16(address+i), value)
+		}
+		return "OK"
+	case strings.HasPrefix(data, "Z"), strings.HasPrefix(data, "z"):
+		return s.setPoint(data[0] == 'Z', data[1:])
+	}
+	// Anything else isn't supported, which is said with an empty reply.
+	return ""
+}
+
+// Registers in the order GDB expects them. The ones the Game Boy doesn't have are 0.
+func (s *Stub) register(n int) uint16 {
+	r := s.Game.R
+	switch n {
+	case 0:
+		return r.AF()
+	case 1:
+		return r.BC()
+	case 2:
+		return r.DE()
+	case 3:
+		return r.HL()
+	case 4:
+		return r.SP
+	case 5:
+		return r.PC
+	}
+	return 0
+}
+
+func (s *Stub) setRegister(n int, value uint16) {
+	r := s.Game.R
+	switch n {
+	case 0:
+		r.A, r.F = byte(value>>8), byte(value)
+		// The flags are kept as booleans too.
+		r.ZF = r.F&0x80 != 0
+		r.NF = r.F&0x40 != 0
+		r.HF = r.F&0x20 != 0
+		r.CF = r.F&0x10 != 0
+	case 1:
+		r.B, r.C = byte(value>>8), byte(value)
+	case 2:
+		r.D, r.E = byte(value>>8), byte(value)
+	case 3:
+		r.H, r.L = byte(value>>8), byte(value)
+	case 4:
+		r.SP = value
+	case 5:
+		r.PC = value
+	}
+}
+
+// Continue and step can say where to resume from. It returns false if the address isn't valid, after replying.
+func (s *Stub) resumeAt(address string) bool {
+	if address == "" {
+		return true
+	}
+	pc, err := strconv.ParseUint(address, 16, 16)
+	if err != nil {
+		s.send("E01")
+		return false
+	}
+	s.mu.Lock()
+	s.Game.R.PC = uint16(pc)
+	s.mu.Unlock()
+	return true
+}
+
+// Execute one instruction, or one cycle if the CPU is halted. It returns whether the frame is over
+// and, if the game has to stop there, the stop reply. It has to be called with the lock held.
+func (s *Stub) step(single bool) (bool, string) {
+	g := s.Game
+	executed := !g.R.Halted
+	s.watchHit = ""
+	frameOver, err := g.Step()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false, fmt.Sprintf("S%02x", sigill)
+	}
+	if s.watchHit != "" {
+		return frameOver, s.watchHit
+	}
+	if single && executed || s.breakpoints[g.R.PC] {
+		return frameOver, fmt.Sprintf("S%02x", sigtrap)
+	}
+	return frameOver, ""
+}
+
+// Run the game here until it has to stop or GDB interrupts it, and return the stop reply.
+// With single, it stops after one instruction. The lock is released after every frame.
+func (s *Stub) run(single bool) string {
+	for {
+		s.mu.Lock()
+		stop := ""
+		for frameOver := false; !frameOver && stop == ""; {
+			frameOver, stop = s.step(single)
+		}
+		s.mu.Unlock()
+		if stop != "" {
+			return stop
+		}
+		select {
+		case p, ok := <-s.packets:
+			if !ok || p.interrupt {
+				return fmt.Sprintf("S%02x", sigint)
+			}
+		default:
+		}
+	}
+}
+
+// Let the window run frames until one of them stops the game or GDB interrupts it, and return the stop reply.
+func (s *Stub) resume() string {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+	for {
+		select {
+		case stop := <-s.stopped:
+			return stop
+		case p, ok := <-s.packets:
+			if ok && !p.interrupt {
+				// Nothing but interrupts is expected while the game runs.
+				continue
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// A frame could have stopped it right before.
+			select {
+			case stop := <-s.stopped:
+				return stop
+			default:
+			}
+			s.paused = true
+			return fmt.Sprintf("S%02x", sigint)
+		}
+	}
+}
+
+// Read the packets sent by GDB, acknowledging them, until the connection is closed.
+func (s *Stub) readPackets() {
+	defer close(s.packets)
+	r := bufio.NewReader(s.conn)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch c {
+		case 0x03:
+			s.packets <- packet{interrupt: true}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			sum := make([]byte, 2)
+			if _, err = io.ReadFull(r, sum); err != nil {
+				return
+			}
+			checksum, err := strconv.ParseUint(string(sum), 16, 8)
+			if !s.noAck {
+				if err != nil || byte(checksum) != checksumOf(data) {
+					s.write("-")
+					continue
+				}
+				s.write("+")
+			}
+			s.packets <- packet{data: unescape(data)}
+		}
+		// Acknowledgements of our replies are ignored.
+	}
+}
+
+// Send a reply packet.
+func (s *Stub) send(data string) {
+	s.write(fmt.Sprintf("$%s#%02x", data, checksumOf(data)))
+}
+
+func (s *Stub) write(text string) {
+	// If the connection is broken, the reader finds out and everything stops.
+	_, _ = io.WriteString(s.conn, text)
+}
+
+func checksumOf(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// Bytes in packets can be escaped with a "}" followed by the byte XORed with 0x20.
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			b.WriteByte(data[i] ^ 0x20)
+		} else {
+			b.WriteByte(data[i])
+		}
+	}
+	return b.String()
+}
+
+// Parse "address,length", both in hex, making sure it's all inside the 64KB the Game Boy can address.
+func parseRange(text string) (int, int, bool) {
+	fields := strings.SplitN(text, ",", 2)
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+	address, err1 := strconv.ParseUint(fields[0], 16, 32)
+	length, err2 := strconv.ParseUint(fields[1], 16, 32)
+	if err1 != nil || err2 != nil || address+length > 0x10000 {
+		return 0, 0, false
+	}
+	return int(address), int(length), true
+}
@@ -0,0 +1,197 @@
+package gdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go-boy/internal/game"
+	"io"
+	"net"
+	"testing"
+)
+
+// A game that keeps increasing C000:
+//
+//	0100 LD HL,$C000
+//	0103 INC (HL)
+//	0104 JR $0103
+func newGame() *game.Game {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{0x21, 0x00, 0xC0, 0x34, 0x18, 0xFD})
+	return game.New(bytes.NewReader(rom))
+}
+
+// GDB's side of the connection.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Send a packet and return the reply, checking that both are acknowledged and well formed.
+func (c *client) request(data string) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", data, checksumOf(data)); err != nil {
+		c.t.Fatal(err)
+	}
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: got ack %q, %v", data, ack, err)
+	}
+	if start, err := c.r.ReadByte(); err != nil || start != '$' {
+		c.t.Fatalf("%s: got %q, %v instead of a reply", data, start, err)
+	}
+	reply, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	reply = reply[:len(reply)-1]
+	sum := make([]byte, 2)
+	if _, err = io.ReadFull(c.r, sum); err != nil {
+		c.t.Fatal(err)
+	}
+	if string(sum) != fmt.Sprintf("%02x", checksumOf(reply)) {
+		c.t.Fatalf("%s: wrong checksum %s for the reply %q", data, sum, reply)
+	}
+	return reply
+}
+
+func TestSession(t *testing.T) {
+	g := newGame()
+	conn, stubConn := net.Pipe()
+	s := New(stubConn, g)
+	done := make(chan struct{})
+	go func() {
+		s.Run(false)
+		close(done)
+	}()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+	tests := []struct {
+		request, reply string
+	}{
+		{"?", "S05"},
+		{"qSupported:multiprocess+", "PacketSize=4000;QStartNoAckMode+"},
+		{"qAttached", "1"},
+		{"vMustReplyEmpty", ""},
+		{"m0100,6", "2100c03418fd"},
+		{"m0100", "E01"},
+		{"mffff,2", "E01"},
+		{"p5", "0001"},
+		{"p20", "E01"},
+		// Run to the breakpoint, and again around the loop.
+		{"Z0,103,1", "OK"},
+		{"c", "S05"},
+		{"p5", "0301"},
+		{"p3", "00c0"},
+		{"c", "S05"},
+		{"mc000,1", "01"},
+		{"z0,103,1", "OK"},
+		{"s", "S05"},
+		{"p5", "0401"},
+		// Watchpoints stop on the instruction that touches the memory.
+		{"Z2,c000,1", "OK"},
+		{"c", "T05watch:c000;"},
+		{"p5", "0401"},
+		{"mc000,1", "03"},
+		{"z2,c000,1", "OK"},
+		{"Z3,c000,1", "OK"},
+		{"c", "T05rwatch:c000;"},
+		{"Z9,0,1", ""},
+		// Registers and memory can be changed.
+		{"P3=00c1", "OK"},
+		{"p3", "00c1"},
+		{"Mc100,2:abcd", "OK"},
+		{"mc100,2", "abcd"},
+		{"Mc100,2:ab", "E01"},
+	}
+	for _, test := range tests {
+		if reply := c.request(test.request); reply != test.reply {
+			t.Errorf("%s: got %q, want %q", test.request, reply, test.reply)
+		}
+	}
+
+	conn.Close()
+	<-done
+}
+
+// Disconnecting without detaching lets the game run on its own, without the points GDB left behind.
+func TestDisconnect(t *testing.T) {
+	g := newGame()
+	conn, stubConn := net.Pipe()
+	s := New(stubConn, g)
+	done := make(chan struct{})
+	go func() {
+		s.Run(false)
+		close(done)
+	}()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+	for _, request := range []string{"Z0,103,1", "Z2,c000,1"} {
+		if reply := c.request(request); reply != "OK" {
+			t.Fatalf("%s: got %q", request, reply)
+		}
+	}
+	conn.Close()
+	<-done
+	if len(s.breakpoints) != 0 || len(s.watchpoints) != 0 || g.Watch != nil {
+		t.Errorf("breakpoints %v, watchpoints %v and watcher %v left after disconnecting",
+			s.breakpoints, s.watchpoints, g.Watch)
+	}
+	frame := g.Frame
+	for i := 0; i < 3; i++ {
+		if err := s.Frame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.Frame != frame+3 {
+		t.Errorf("ran %d frames after disconnecting, want 3", g.Frame-frame)
+	}
+}
+
+func TestChecksumsAndEscapes(t *testing.T) {
+	tests := []struct {
+		data     string
+		checksum byte
+	}{
+		{"", 0x00},
+		{"OK", 0x9a},
+		{"g", 0x67},
+		{"S05", 0xb8},
+	}
+	for _, test := range tests {
+		if checksum := checksumOf(test.data); checksum != test.checksum {
+			t.Errorf("checksum of %q: got %02x, want %02x", test.data, checksum, test.checksum)
+		}
+	}
+	escapes := map[string]string{
+		"abc":     "abc",
+		"}\x03":   "#",
+		"a}]b":    "a}b",
+		"}\x04}]": "$}",
+	}
+	for escaped, data := range escapes {
+		if got := unescape(escaped); got != data {
+			t.Errorf("unescape(%q): got %q, want %q", escaped, got, data)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		text            string
+		address, length int
+		ok              bool
+	}{
+		{"0,1", 0, 1, true},
+		{"c000,10", 0xC000, 0x10, true},
+		{"fff0,10", 0xFFF0, 0x10, true},
+		{"fff0,11", 0, 0, false},
+		{"c000", 0, 0, false},
+		{"x,1", 0, 0, false},
+	}
+	for _, test := range tests {
+		address, length, ok := parseRange(test.text)
+		if address != test.address || length != test.length || ok != test.ok {
+			t.Errorf("parseRange(%q): got %x, %x, %v, want %x, %x, %v",
+				test.text, address, length, ok, test.address, test.length, test.ok)
+		}
+	}
+}
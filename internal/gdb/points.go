@@ -0,0 +1,98 @@
+package gdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of breakpoints and watchpoints, as numbered in the Z and z packets.
+const (
+	softwareBreakpoint = iota
+	hardwareBreakpoint
+	writeWatchpoint
+	readWatchpoint
+	accessWatchpoint
+)
+
+// Watchpoint pauses the game when an instruction reads or writes some bytes.
+type watchpoint struct {
+	kind    int
+	address uint16
+	length  int
+}
+
+func (w watchpoint) contains(address uint16) bool {
+	return int(address) >= int(w.address) && int(address) < int(w.address)+w.length
+}
+
+// Set or remove a breakpoint or watchpoint from a "kind,address,length" Z or z packet.
+func (s *Stub) setPoint(set bool, text string) string {
+	fields := strings.Split(text, ",")
+	if len(fields) < 3 {
+		return "E01"
+	}
+	kind, err := strconv.Atoi(fields[0])
+	if err != nil || kind > accessWatchpoint {
+		// Unknown kinds aren't supported.
+		return ""
+	}
+	address, length, ok := parseRange(fields[1] + "," + fields[2])
+	if !ok {
+		return "E01"
+	}
+	if kind == softwareBreakpoint || kind == hardwareBreakpoint {
+		if set {
+			s.breakpoints[uint16(address)] = true
+		} else {
+			delete(s.breakpoints, uint16(address))
+		}
+		return "OK"
+	}
+	w := watchpoint{kind: kind, address: uint16(address), length: length}
+	if set {
+		s.watchpoints = append(s.watchpoints, w)
+	} else {
+		for i := range s.watchpoints {
+			if s.watchpoints[i] == w {
+				s.watchpoints = append(s.watchpoints[:i], s.watchpoints[i+1:]...)
+				break
+			}
+		}
+	}
+	// Only watch the memory while there's something to watch.
+	if len(s.watchpoints) > 0 {
+		s.Game.Watch = s
+	} else {
+		s.Game.Watch = nil
+	}
+	return "OK"
+}
+
+// Read implements memory.Watcher, while there are watchpoints. The first hit is kept until the instruction is over.
+func (s *Stub) Read(address uint16, _ byte) {
+	for _, w := range s.watchpoints {
+		if (w.kind == readWatchpoint || w.kind == accessWatchpoint) && w.contains(address) {
+			s.hit(w, address)
+			return
+		}
+	}
+}
+
+// Write implements memory.Watcher, hitting the write and access watchpoints.
+func (s *Stub) Write(address uint16, _, _ byte) {
+	for _, w := range s.watchpoints {
+		if (w.kind == writeWatchpoint || w.kind == accessWatchpoint) && w.contains(address) {
+			s.hit(w, address)
+			return
+		}
+	}
+}
+
+func (s *Stub) hit(w watchpoint, address uint16) {
+	if s.watchHit != "" {
+		return
+	}
+	name := map[int]string{writeWatchpoint: "watch", readWatchpoint: "rwatch", accessWatchpoint: "awatch"}[w.kind]
+	s.watchHit = fmt.Sprintf("T%02x%s:%x;", sigtrap, name, address)
+}
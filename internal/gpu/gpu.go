@@ -1,6 +1,9 @@
 package gpu
 
-import "go-boy/internal/memory"
+import (
+	"go-boy/internal/memory"
+	"go-boy/internal/state"
+)
 
 type GPU struct {
 	ticks           int
@@ -74,3 +77,12 @@ func (gpu *GPU) Step(cycles int, m *memory.Memory) {
 		m.Store(0xFF41, m.Read(0xFF41)&0xBF)
 	}
 }
+
+// SaveState writes the GPU's counters, to carry on drawing from the same point after LoadState.
+func (gpu *GPU) SaveState(e *state.Encoder) {
+	e.Write(gpu.ticks, gpu.scanLine, gpu.Mode, gpu.VBlankInterrupt)
+}
+
+func (gpu *GPU) LoadState(d *state.Decoder) {
+	d.Read(&gpu.ticks, &gpu.scanLine, &gpu.Mode, &gpu.VBlankInterrupt)
+}
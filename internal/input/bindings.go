@@ -0,0 +1,343 @@
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-boy/internal/joypad"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Bindings tells which keys and controller buttons press each of the Game Boy buttons.
+// They can be loaded from a JSON file like this one, where any missing button keeps its default:
+//
+//	{
+//	  "keys": {"a": ["Z", "Space"], "b": ["X"]},
+//	  "buttons": {"a": ["RightBottom"], "b": ["RightRight"]},
+//	  "gamepads": [0, 1],
+//	  "stick_threshold": 0.5,
+//	  "turbo_keys": {"a": ["A"], "b": ["S"]},
+//	  "turbo_buttons": {"a": ["RightLeft"], "b": ["RightTop"]},
+//	  "turbo_rate": 2,
+//	  "macros": {"R": "a+b+select+start:5"}
+//	}
+type Bindings struct {
+	// Keyboard keys for every Game Boy button, by their ebiten names ("Z", "ArrowUp", "Enter"...).
+	Keys map[string][]string `json:"keys"`
+	// Controller buttons for every Game Boy button, by their position in the standard layout
+	// ("RightBottom", "LeftTop", "CenterRight"...).
+	Buttons map[string][]string `json:"buttons"`
+	// IDs of the controllers that drive the joypad. If empty, all the connected ones do.
+	Gamepads []int `json:"gamepads"`
+	// How far the left stick has to be pushed to press a direction, from 0 to 1. 0 means the stick is ignored.
+	StickThreshold float64 `json:"stick_threshold"`
+	// Keys and controller buttons that press a button over and over while held, meant for A and B.
+	TurboKeys    map[string][]string `json:"turbo_keys"`
+	TurboButtons map[string][]string `json:"turbo_buttons"`
+	// How many frames turbo buttons stay pressed, and then released.
+	TurboRate int `json:"turbo_rate"`
+	// Macros played when a key is pressed, by key name. See joypad.ParseMacro for how they're written.
+	Macros map[string]string `json:"macros"`
+}
+
+// Names of the buttons in the standard controller layout, in the same order as ebiten's constants.
+var gamepadButtonNames = [...]string{
+	"RightBottom", "RightRight", "RightLeft", "RightTop",
+	"FrontTopLeft", "FrontTopRight", "FrontBottomLeft", "FrontBottomRight",
+	"CenterLeft", "CenterRight", "LeftStick", "RightStick",
+	"LeftTop", "LeftBottom", "LeftLeft", "LeftRight", "CenterCenter",
+}
+
+// DefaultBindings returns the mapping described in the README.
+func DefaultBindings() *Bindings {
+	return &Bindings{
+		Keys: map[string][]string{
+			"a":      {"Z"},
+			"b":      {"X"},
+			"select": {"Backspace"},
+			"start":  {"Enter"},
+			"right":  {"ArrowRight"},
+			"left":   {"ArrowLeft"},
+			"up":     {"ArrowUp"},
+			"down":   {"ArrowDown"},
+		},
+		Buttons: map[string][]string{
+			"a":      {"RightBottom"},
+			"b":      {"RightRight"},
+			"select": {"CenterLeft"},
+			"start":  {"CenterRight"},
+			"right":  {"LeftRight"},
+			"left":   {"LeftLeft"},
+			"up":     {"LeftTop"},
+			"down":   {"LeftBottom"},
+		},
+		Gamepads:       []int{0},
+		StickThreshold: 0.5,
+		TurboKeys: map[string][]string{
+			"a": {"A"},
+			"b": {"S"},
+		},
+		TurboButtons: map[string][]string{
+			"a": {"RightLeft"},
+			"b": {"RightTop"},
+		},
+		TurboRate: 2,
+		Macros:    map[string]string{},
+	}
+}
+
+// LoadBindings reads the bindings in a JSON file on top of the default ones.
+func LoadBindings(filename string) (*Bindings, error) {
+	b := DefaultBindings()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return b, nil
+}
+
+// BindKeys overrides the keys of one button with a "button=key,key..." spec, like "a=Z,Space".
+func (b *Bindings) BindKeys(spec string) error {
+	button, keys, err := splitSpec(spec)
+	if err != nil {
+		return err
+	}
+	b.Keys[button] = keys
+	return nil
+}
+
+// BindButtons overrides the controller buttons of one button with a "button=button,button..." spec,
+// like "a=RightBottom,RightLeft".
+func (b *Bindings) BindButtons(spec string) error {
+	button, buttons, err := splitSpec(spec)
+	if err != nil {
+		return err
+	}
+	b.Buttons[button] = buttons
+	return nil
+}
+
+// BindTurboKeys overrides the turbo keys of one button with a "button=key,key..." spec, like "a=A".
+func (b *Bindings) BindTurboKeys(spec string) error {
+	button, keys, err := splitSpec(spec)
+	if err != nil {
+		return err
+	}
+	b.TurboKeys[button] = keys
+	return nil
+}
+
+// BindTurboButtons overrides the turbo controller buttons of one button with a "button=button,button..." spec,
+// like "a=RightLeft".
+func (b *Bindings) BindTurboButtons(spec string) error {
+	button, buttons, err := splitSpec(spec)
+	if err != nil {
+		return err
+	}
+	b.TurboButtons[button] = buttons
+	return nil
+}
+
+// BindMacro binds a macro to a key with a "key=macro" spec, like "R=a+b+select+start:5".
+func (b *Bindings) BindMacro(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid macro %q, expected key=macro", spec)
+	}
+	b.Macros[strings.TrimSpace(parts[0])] = parts[1]
+	return nil
+}
+
+// SetGamepads picks the controllers that drive the joypad from a comma separated list of IDs,
+// or "all" for every connected one.
+func (b *Bindings) SetGamepads(list string) error {
+	b.Gamepads = nil
+	if list == "all" {
+		return nil
+	}
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("invalid gamepad ID %q", field)
+		}
+		b.Gamepads = append(b.Gamepads, id)
+	}
+	return nil
+}
+
+func splitSpec(spec string) (string, []string, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("invalid binding %q, expected button=name,name", spec)
+	}
+	button := strings.ToLower(strings.TrimSpace(parts[0]))
+	var names []string
+	for _, name := range strings.Split(parts[1], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return button, names, nil
+}
+
+// Bindings resolved to ebiten's types, one entry per bit of joypad.State.
+type resolvedBindings struct {
+	keys         [8][]ebiten.Key
+	buttons      [8][]ebiten.StandardGamepadButton
+	turboKeys    [8][]ebiten.Key
+	turboButtons [8][]ebiten.StandardGamepadButton
+	turboRate    int
+	macros       []keyMacro
+	gamepads     []ebiten.GamepadID
+	stick        float64
+}
+
+// A macro and the key that plays it.
+type keyMacro struct {
+	key   ebiten.Key
+	macro joypad.Macro
+}
+
+// CheckHotkeys returns an error if a key bound to a button, a turbo button or a macro is one of the given hotkeys,
+// which are used for something else, as said in their values.
+func (b *Bindings) CheckHotkeys(hotkeys map[ebiten.Key]string) error {
+	rb, err := b.resolve()
+	if err != nil {
+		return err
+	}
+	for _, bound := range []struct {
+		keys  [8][]ebiten.Key
+		turbo string
+	}{{rb.keys, ""}, {rb.turboKeys, "turbo "}} {
+		for bit, keys := range bound.keys {
+			for _, k := range keys {
+				if use, ok := hotkeys[k]; ok {
+					return fmt.Errorf("key %s can't be bound to %sbutton %s, it's the hotkey for %s",
+						k, bound.turbo, joypad.State(1<<bit), use)
+				}
+			}
+		}
+	}
+	for _, m := range rb.macros {
+		if use, ok := hotkeys[m.key]; ok {
+			return fmt.Errorf("key %s can't be bound to a macro, it's the hotkey for %s", m.key, use)
+		}
+	}
+	return nil
+}
+
+// Translate the names in the bindings to ebiten's keys and buttons, checking that all of them exist.
+func (b *Bindings) resolve() (*resolvedBindings, error) {
+	var err error
+	rb := &resolvedBindings{stick: b.StickThreshold, turboRate: b.TurboRate}
+	if b.StickThreshold < 0 || b.StickThreshold > 1 {
+		return nil, fmt.Errorf("stick threshold must be between 0 and 1, got %v", b.StickThreshold)
+	}
+	if b.TurboRate < 1 {
+		return nil, fmt.Errorf("turbo rate must be at least 1 frame, got %d", b.TurboRate)
+	}
+
+	keysByName := make(map[string]ebiten.Key)
+	for k := ebiten.Key(0); k <= ebiten.KeyMax; k++ {
+		if name := k.String(); name != "" {
+			keysByName[strings.ToLower(name)] = k
+		}
+	}
+
+	if rb.keys, err = resolveKeys(b.Keys, keysByName); err != nil {
+		return nil, err
+	}
+	if rb.turboKeys, err = resolveKeys(b.TurboKeys, keysByName); err != nil {
+		return nil, err
+	}
+	if rb.buttons, err = resolveButtons(b.Buttons); err != nil {
+		return nil, err
+	}
+	if rb.turboButtons, err = resolveButtons(b.TurboButtons); err != nil {
+		return nil, err
+	}
+
+	for name, text := range b.Macros {
+		k, ok := keysByName[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q for macro", name)
+		}
+		macro, err := joypad.ParseMacro(text)
+		if err != nil {
+			return nil, err
+		}
+		rb.macros = append(rb.macros, keyMacro{key: k, macro: macro})
+	}
+	// Keep the macros in a fixed order, so that the same keys always play the same one.
+	sort.Slice(rb.macros, func(i, j int) bool { return rb.macros[i].key < rb.macros[j].key })
+
+	for _, id := range b.Gamepads {
+		rb.gamepads = append(rb.gamepads, ebiten.GamepadID(id))
+	}
+	return rb, nil
+}
+
+func resolveKeys(bindings map[string][]string, keysByName map[string]ebiten.Key) ([8][]ebiten.Key, error) {
+	var keys [8][]ebiten.Key
+	for button, names := range bindings {
+		bit, err := buttonBit(button)
+		if err != nil {
+			return keys, err
+		}
+		for _, name := range names {
+			k, ok := keysByName[strings.ToLower(name)]
+			if !ok {
+				return keys, fmt.Errorf("unknown key %q for button %s", name, button)
+			}
+			keys[bit] = append(keys[bit], k)
+		}
+	}
+	return keys, nil
+}
+
+func resolveButtons(bindings map[string][]string) ([8][]ebiten.StandardGamepadButton, error) {
+	var buttons [8][]ebiten.StandardGamepadButton
+	for button, names := range bindings {
+		bit, err := buttonBit(button)
+		if err != nil {
+			return buttons, err
+		}
+		for _, name := range names {
+			found := false
+			for i, n := range gamepadButtonNames {
+				if strings.EqualFold(n, name) {
+					buttons[bit] = append(buttons[bit], ebiten.StandardGamepadButton(i))
+					found = true
+				}
+			}
+			if !found {
+				return buttons, fmt.Errorf("unknown controller button %q for button %s", name, button)
+			}
+		}
+	}
+	return buttons, nil
+}
+
+// Position of a Game Boy button in joypad.State.
+func buttonBit(name string) (int, error) {
+	button, ok := joypad.ButtonNamed(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown Game Boy button %q", name)
+	}
+	bit := 0
+	for button > 1 {
+		button >>= 1
+		bit++
+	}
+	return bit, nil
+}
@@ -0,0 +1,209 @@
+package input
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+func TestDefaultBindings(t *testing.T) {
+	rb, err := DefaultBindings().resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		bit    int
+		key    ebiten.Key
+		button ebiten.StandardGamepadButton
+	}{
+		{0, ebiten.KeyZ, ebiten.StandardGamepadButtonRightBottom},
+		{1, ebiten.KeyX, ebiten.StandardGamepadButtonRightRight},
+		{2, ebiten.KeyBackspace, ebiten.StandardGamepadButtonCenterLeft},
+		{3, ebiten.KeyEnter, ebiten.StandardGamepadButtonCenterRight},
+		{4, ebiten.KeyArrowRight, ebiten.StandardGamepadButtonLeftRight},
+		{5, ebiten.KeyArrowLeft, ebiten.StandardGamepadButtonLeftLeft},
+		{6, ebiten.KeyArrowUp, ebiten.StandardGamepadButtonLeftTop},
+		{7, ebiten.KeyArrowDown, ebiten.StandardGamepadButtonLeftBottom},
+	}
+	for _, test := range tests {
+		if len(rb.keys[test.bit]) != 1 || rb.keys[test.bit][0] != test.key {
+			t.Errorf("bit %d: got keys %v, want %v", test.bit, rb.keys[test.bit], test.key)
+		}
+		if len(rb.buttons[test.bit]) != 1 || rb.buttons[test.bit][0] != test.button {
+			t.Errorf("bit %d: got buttons %v, want %v", test.bit, rb.buttons[test.bit], test.button)
+		}
+	}
+	if len(rb.gamepads) != 1 || rb.gamepads[0] != 0 || rb.stick != 0.5 {
+		t.Errorf("got gamepads %v and stick threshold %v", rb.gamepads, rb.stick)
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	b := DefaultBindings()
+	if err := b.BindKeys("A= z, space ,"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BindButtons("start=centerright,RightTop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetGamepads("1, 3"); err != nil {
+		t.Fatal(err)
+	}
+	rb, err := b.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := rb.keys[0]; len(keys) != 2 || keys[0] != ebiten.KeyZ || keys[1] != ebiten.KeySpace {
+		t.Errorf("got keys %v for a", keys)
+	}
+	if buttons := rb.buttons[3]; len(buttons) != 2 || buttons[1] != ebiten.StandardGamepadButtonRightTop {
+		t.Errorf("got buttons %v for start", buttons)
+	}
+	if len(rb.gamepads) != 2 || rb.gamepads[0] != 1 || rb.gamepads[1] != 3 {
+		t.Errorf("got gamepads %v", rb.gamepads)
+	}
+	if err = b.SetGamepads("all"); err != nil || b.Gamepads != nil {
+		t.Errorf("got gamepads %v, %v for all of them", b.Gamepads, err)
+	}
+}
+
+func TestBindingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		bind func(b *Bindings) error
+		err  string
+	}{
+		{"spec", func(b *Bindings) error { return b.BindKeys("a") }, `invalid binding "a"`},
+		{"gamepad", func(b *Bindings) error { return b.SetGamepads("1,x") }, `invalid gamepad ID "x"`},
+		{"button", func(b *Bindings) error { return b.BindKeys("c=Z") }, `unknown Game Boy button "c"`},
+		{"key", func(b *Bindings) error { return b.BindKeys("a=Nope") }, `unknown key "Nope" for button a`},
+		{"controller button", func(b *Bindings) error { return b.BindButtons("b=Trigger") }, `unknown controller button "Trigger" for button b`},
+		{"stick", func(b *Bindings) error { b.StickThreshold = 2; return nil }, "stick threshold must be between 0 and 1"},
+	}
+	for _, test := range tests {
+		b := DefaultBindings()
+		err := test.bind(b)
+		if err == nil {
+			_, err = b.resolve()
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestLoadBindings(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bindings.json")
+	config := `{"keys": {"a": ["Space"]}, "gamepads": [2], "stick_threshold": 0}`
+	if err := os.WriteFile(filename, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBindings(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What isn't in the file keeps its default.
+	if a, bKeys := b.Keys["a"], b.Keys["b"]; len(a) != 1 || a[0] != "Space" || len(bKeys) != 1 || bKeys[0] != "X" {
+		t.Errorf("got keys %v", b.Keys)
+	}
+	if len(b.Gamepads) != 1 || b.Gamepads[0] != 2 || b.StickThreshold != 0 {
+		t.Errorf("got gamepads %v and stick threshold %v", b.Gamepads, b.StickThreshold)
+	}
+
+	if err = os.WriteFile(filename, []byte(`{"keys": [}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadBindings(filename); err == nil || !strings.HasPrefix(err.Error(), filename+": ") {
+		t.Errorf("got error %v for a broken file", err)
+	}
+}
+
+func TestTurboAndMacros(t *testing.T) {
+	b := DefaultBindings()
+	if err := b.BindTurboKeys("b=Q"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BindTurboButtons("a=FrontTopRight"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BindMacro("T=a:2,:2,a:2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BindMacro("R=a+b+select+start:5"); err != nil {
+		t.Fatal(err)
+	}
+	rb, err := b.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := rb.turboKeys[1]; len(keys) != 1 || keys[0] != ebiten.KeyQ {
+		t.Errorf("got turbo keys %v for b", keys)
+	}
+	if keys := rb.turboKeys[0]; len(keys) != 1 || keys[0] != ebiten.KeyA {
+		t.Errorf("got turbo keys %v for a", keys)
+	}
+	if buttons := rb.turboButtons[0]; len(buttons) != 1 || buttons[0] != ebiten.StandardGamepadButtonFrontTopRight {
+		t.Errorf("got turbo buttons %v for a", buttons)
+	}
+	// Macros are sorted by key.
+	if len(rb.macros) != 2 || rb.macros[0].key != ebiten.KeyR || rb.macros[1].key != ebiten.KeyT {
+		t.Fatalf("got macros %v", rb.macros)
+	}
+	if rb.turboRate != 2 {
+		t.Errorf("got a turbo rate of %d", rb.turboRate)
+	}
+}
+
+func TestTurboAndMacroErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		bind func(b *Bindings) error
+		err  string
+	}{
+		{"macro spec", func(b *Bindings) error { return b.BindMacro("R") }, `invalid macro "R"`},
+		{"macro key", func(b *Bindings) error { return b.BindMacro("Nope=a:1") }, `unknown key "Nope" for macro`},
+		{"turbo key", func(b *Bindings) error { return b.BindTurboKeys("a=Nope") }, `unknown key "Nope" for button a`},
+		{"rate", func(b *Bindings) error { b.TurboRate = 0; return nil }, "turbo rate must be at least 1 frame"},
+	}
+	for _, test := range tests {
+		b := DefaultBindings()
+		err := test.bind(b)
+		if err == nil {
+			_, err = b.resolve()
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCheckHotkeys(t *testing.T) {
+	hotkeys := map[ebiten.Key]string{ebiten.KeyTab: "rewinding", ebiten.KeyF1: "save state slot 1"}
+	tests := []struct {
+		name string
+		bind func(b *Bindings) error
+		err  string
+	}{
+		{"defaults", func(b *Bindings) error { return nil }, ""},
+		{"key", func(b *Bindings) error { return b.BindKeys("start=Enter,Tab") },
+			"key Tab can't be bound to button start, it's the hotkey for rewinding"},
+		{"turbo key", func(b *Bindings) error { return b.BindTurboKeys("a=F1") },
+			"key F1 can't be bound to turbo button a, it's the hotkey for save state slot 1"},
+		{"macro", func(b *Bindings) error { return b.BindMacro("tab=a:1") },
+			"key Tab can't be bound to a macro, it's the hotkey for rewinding"},
+		{"unknown key", func(b *Bindings) error { return b.BindKeys("a=Nope") }, `unknown key "Nope" for button a`},
+	}
+	for _, test := range tests {
+		b := DefaultBindings()
+		if err := test.bind(b); err != nil {
+			t.Fatal(err)
+		}
+		err := b.CheckHotkeys(hotkeys)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package input
+
+import (
+	"go-boy/internal/joypad"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Ebiten reads the joypad state from the keyboard and the controllers through ebiten.
+// Turbo buttons and macros are applied here too, once per frame, so they're as deterministic as the rest of the input.
+type Ebiten struct {
+	bindings *resolvedBindings
+	turbo    joypad.Turbo
+	macros   joypad.MacroPlayer
+	// Whether each macro key was pressed in the previous frame, so that holding it plays the macro only once.
+	macroKeys []bool
+	// Reused between polls to avoid allocating a new slice every frame.
+	gamepadIDs []ebiten.GamepadID
+}
+
+// NewEbiten returns an input source that uses the given bindings.
+// It fails if any of the keys or buttons in them doesn't exist.
+func NewEbiten(b *Bindings) (*Ebiten, error) {
+	rb, err := b.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return &Ebiten{
+		bindings:  rb,
+		turbo:     joypad.Turbo{Rate: rb.turboRate},
+		macroKeys: make([]bool, len(rb.macros)),
+	}, nil
+}
+
+// Poll returns the buttons held down either in the keyboard or in any of the chosen controllers,
+// plus the ones pressed by turbo buttons and macros.
+func (e *Ebiten) Poll() joypad.State {
+	gamepads := e.connectedGamepads()
+	state := e.held(e.bindings.keys, e.bindings.buttons, gamepads)
+	for _, id := range gamepads {
+		if ebiten.IsStandardGamepadLayoutAvailable(id) {
+			state |= e.stickDirections(id)
+		}
+	}
+
+	state |= e.turbo.Apply(e.held(e.bindings.turboKeys, e.bindings.turboButtons, gamepads))
+
+	for i, m := range e.bindings.macros {
+		pressed := ebiten.IsKeyPressed(m.key)
+		if pressed && !e.macroKeys[i] {
+			e.macros.Play(m.macro)
+		}
+		e.macroKeys[i] = pressed
+	}
+	return state | e.macros.Next()
+}
+
+// Which buttons are held down with the given keys and controller buttons.
+func (e *Ebiten) held(keys [8][]ebiten.Key, buttons [8][]ebiten.StandardGamepadButton, gamepads []ebiten.GamepadID) joypad.State {
+	state := joypad.State(0)
+	for bit := range keys {
+		for _, k := range keys[bit] {
+			if ebiten.IsKeyPressed(k) {
+				state |= 1 << bit
+			}
+		}
+	}
+	for _, id := range gamepads {
+		if !ebiten.IsStandardGamepadLayoutAvailable(id) {
+			continue
+		}
+		for bit := range buttons {
+			for _, b := range buttons[bit] {
+				if ebiten.IsStandardGamepadButtonPressed(id, b) {
+					state |= 1 << bit
+				}
+			}
+		}
+	}
+	return state
+}
+
+// The controllers connected right now that are allowed to drive the joypad.
+func (e *Ebiten) connectedGamepads() []ebiten.GamepadID {
+	e.gamepadIDs = ebiten.AppendGamepadIDs(e.gamepadIDs[:0])
+	if len(e.bindings.gamepads) == 0 {
+		return e.gamepadIDs
+	}
+	chosen := e.gamepadIDs[:0]
+	for _, id := range e.gamepadIDs {
+		for _, wanted := range e.bindings.gamepads {
+			if id == wanted {
+				chosen = append(chosen, id)
+			}
+		}
+	}
+	return chosen
+}
+
+// Translate the position of the left stick to D-pad directions.
+func (e *Ebiten) stickDirections(id ebiten.GamepadID) joypad.State {
+	state := joypad.State(0)
+	if e.bindings.stick == 0 {
+		return state
+	}
+	x := ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisLeftStickHorizontal)
+	y := ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisLeftStickVertical)
+	if x >= e.bindings.stick {
+		state |= joypad.Right
+	} else if x <= -e.bindings.stick {
+		state |= joypad.Left
+	}
+	if y >= e.bindings.stick {
+		state |= joypad.Down
+	} else if y <= -e.bindings.stick {
+		state |= joypad.Up
+	}
+	return state
+}
@@ -0,0 +1,70 @@
+package joypad
+
+import "strings"
+
+// State holds which of the 8 Game Boy buttons are held down, one bit per button.
+// The lower nibble are the buttons and the upper nibble the directions, in the same
+// order the Game Boy reads them from 0xFF00, so that each half can be used as is.
+type State byte
+
+const (
+	A State = 1 << iota
+	B
+	Select
+	Start
+	Right
+	Left
+	Up
+	Down
+)
+
+// Buttons returns the state of A, B, Select and Start in the lower nibble.
+func (s State) Buttons() byte {
+	return byte(s) & 0x0F
+}
+
+// Directions returns the state of the D-pad in the lower nibble.
+func (s State) Directions() byte {
+	return byte(s) >> 4
+}
+
+// Source is anything that can tell which buttons are held down: the keyboard, a controller,
+// a script, a replay file, the network...
+// The core doesn't care which one it is, so it can also run without a window.
+type Source interface {
+	// Poll returns the buttons held down right now. It's called once at the start of every frame.
+	Poll() State
+}
+
+// Seeker is a source that plays input back, like a movie, and can go to any of its frames.
+// Save states keep its position, so that loading one goes back to the frame it was saved at.
+type Seeker interface {
+	Source
+	// Position returns the number of frames already played.
+	Position() int
+	Seek(frame int)
+}
+
+// Names of the buttons, in the same order as their bits in a State.
+var names = [8]string{"a", "b", "select", "start", "right", "left", "up", "down"}
+
+// ButtonNamed returns the button with the given name ("a", "start", "up"...). Case doesn't matter.
+func ButtonNamed(name string) (State, bool) {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return State(1 << i), true
+		}
+	}
+	return 0, false
+}
+
+// String returns the names of the buttons held down in s, like "a+up".
+func (s State) String() string {
+	var held []string
+	for i, n := range names {
+		if s&(1<<i) != 0 {
+			held = append(held, n)
+		}
+	}
+	return strings.Join(held, "+")
+}
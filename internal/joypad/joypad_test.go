@@ -0,0 +1,53 @@
+package joypad
+
+import "testing"
+
+func TestButtonNamed(t *testing.T) {
+	tests := []struct {
+		name   string
+		button State
+		ok     bool
+	}{
+		{"a", A, true},
+		{"B", B, true},
+		{"Select", Select, true},
+		{"start", Start, true},
+		{"right", Right, true},
+		{"LEFT", Left, true},
+		{"up", Up, true},
+		{"down", Down, true},
+		{"x", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		button, ok := ButtonNamed(test.name)
+		if button != test.button || ok != test.ok {
+			t.Errorf("ButtonNamed(%q): got %08b, %v, want %08b, %v", test.name, button, ok, test.button, test.ok)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		state               State
+		text                string
+		buttons, directions byte
+	}{
+		{0, "", 0x0, 0x0},
+		{A | Up, "a+up", 0x1, 0x4},
+		{B | Select | Start, "b+select+start", 0xE, 0x0},
+		{Right | Left | Down, "right+left+down", 0x0, 0xB},
+		{0xFF, "a+b+select+start+right+left+up+down", 0xF, 0xF},
+	}
+	for _, test := range tests {
+		if text := test.state.String(); text != test.text {
+			t.Errorf("%08b: got %q, want %q", byte(test.state), text, test.text)
+		}
+		if buttons := test.state.Buttons(); buttons != test.buttons {
+			t.Errorf("%08b: got buttons %04b, want %04b", byte(test.state), buttons, test.buttons)
+		}
+		if directions := test.state.Directions(); directions != test.directions {
+			t.Errorf("%08b: got directions %04b, want %04b", byte(test.state), directions, test.directions)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package joypad
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Turbo presses and releases the buttons it's applied to over and over while they're held,
+// staying Rate frames pressed and Rate frames released.
+// It counts frames, not time, so it always does the same given the same input.
+type Turbo struct {
+	Rate   int
+	frames [8]int // How many frames each button has been held for.
+}
+
+// Apply returns which of the held buttons are pressed this frame. It has to be called once per frame.
+func (t *Turbo) Apply(held State) State {
+	rate := t.Rate
+	if rate < 1 {
+		rate = 1
+	}
+	pressed := State(0)
+	for i := range t.frames {
+		if held&(1<<i) == 0 {
+			t.frames[i] = 0
+			continue
+		}
+		// Start pressed, so that a single tap still presses the button.
+		if (t.frames[i]/rate)%2 == 0 {
+			pressed |= 1 << i
+		}
+		t.frames[i]++
+	}
+	return pressed
+}
+
+// Step is one step of a macro: some buttons held down for a number of frames.
+type Step struct {
+	State  State
+	Frames int
+}
+
+// Macro is a sequence of steps, like the soft reset combo.
+type Macro []Step
+
+// ParseMacro reads a macro written as comma separated steps of "buttons:frames", where buttons are
+// joined with "+" and can be left empty to release everything for a while.
+// For example, "a+b+select+start:5" holds the soft reset combo for 5 frames, and "a:2,:2,a:2" taps A twice.
+// The frames can be left out and default to 1.
+func ParseMacro(text string) (Macro, error) {
+	var macro Macro
+	for _, field := range strings.Split(text, ",") {
+		field = strings.TrimSpace(field)
+		step := Step{Frames: 1}
+		if i := strings.LastIndex(field, ":"); i >= 0 {
+			frames, err := strconv.Atoi(field[i+1:])
+			if err != nil || frames < 1 {
+				return nil, fmt.Errorf("invalid frame count in macro step %q", field)
+			}
+			step.Frames = frames
+			field = field[:i]
+		}
+		for _, name := range strings.Split(field, "+") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			button, ok := ButtonNamed(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown button %q in macro step %q", name, field)
+			}
+			step.State |= button
+		}
+		macro = append(macro, step)
+	}
+	return macro, nil
+}
+
+// MacroPlayer plays a macro one frame at a time.
+type MacroPlayer struct {
+	macro Macro
+	step  int
+	frame int
+}
+
+// Play starts playing a macro from the beginning, unless one is already being played.
+// It returns whether it started.
+func (p *MacroPlayer) Play(m Macro) bool {
+	if p.Playing() {
+		return false
+	}
+	p.macro = m
+	p.step = 0
+	p.frame = 0
+	return true
+}
+
+// Playing tells whether there's a macro still being played.
+func (p *MacroPlayer) Playing() bool {
+	return p.step < len(p.macro)
+}
+
+// Next returns the buttons the macro holds this frame and moves on to the next one.
+// It has to be called once per frame, and returns no buttons when there's nothing being played.
+func (p *MacroPlayer) Next() State {
+	if !p.Playing() {
+		return 0
+	}
+	state := p.macro[p.step].State
+	p.frame++
+	if p.frame >= p.macro[p.step].Frames {
+		p.frame = 0
+		p.step++
+	}
+	return state
+}
@@ -0,0 +1,81 @@
+package joypad
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMacro(t *testing.T) {
+	tests := []struct {
+		text  string
+		macro Macro
+		ok    bool
+	}{
+		{"a+b+select+start:5", Macro{{A | B | Select | Start, 5}}, true},
+		{"a:2,:2,a:2", Macro{{A, 2}, {0, 2}, {A, 2}}, true},
+		{"up, down ,left+right", Macro{{Up, 1}, {Down, 1}, {Left | Right, 1}}, true},
+		{"", Macro{{0, 1}}, true},
+		{"a:0", nil, false},
+		{"a:x", nil, false},
+		{"a+x:2", nil, false},
+	}
+	for _, test := range tests {
+		macro, err := ParseMacro(test.text)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseMacro(%q): got error %v", test.text, err)
+		} else if !reflect.DeepEqual(macro, test.macro) {
+			t.Errorf("ParseMacro(%q): got %v, want %v", test.text, macro, test.macro)
+		}
+	}
+}
+
+func TestMacroPlayer(t *testing.T) {
+	var p MacroPlayer
+	if p.Next() != 0 || p.Playing() {
+		t.Fatal("playing without a macro")
+	}
+	macro := Macro{{A, 2}, {0, 1}, {B | Up, 1}}
+	if !p.Play(macro) {
+		t.Fatal("the macro didn't start")
+	}
+	if p.Play(Macro{{Start, 1}}) {
+		t.Error("another macro started while one was playing")
+	}
+	want := []State{A, A, 0, B | Up, 0, 0}
+	for frame, state := range want {
+		if got := p.Next(); got != state {
+			t.Errorf("frame %d: got %v, want %v", frame, got, state)
+		}
+	}
+	if p.Playing() {
+		t.Error("still playing after the last step")
+	}
+	if !p.Play(macro) {
+		t.Error("the macro can't be played again")
+	}
+}
+
+func TestTurbo(t *testing.T) {
+	tests := []struct {
+		rate  int
+		held  []State
+		wants []State
+	}{
+		{1, []State{A, A, A, A}, []State{A, 0, A, 0}},
+		{2, []State{A, A, A, A, A}, []State{A, A, 0, 0, A}},
+		// A rate of 0 is taken as 1.
+		{0, []State{B, B, B}, []State{B, 0, B}},
+		// Releasing a button starts it over, so every tap presses it.
+		{2, []State{A, A, A, 0, A}, []State{A, A, 0, 0, A}},
+		// Each button keeps its own count.
+		{1, []State{A, A | B, A | B}, []State{A, B, A}},
+	}
+	for _, test := range tests {
+		turbo := Turbo{Rate: test.rate}
+		for frame, held := range test.held {
+			if got := turbo.Apply(held); got != test.wants[frame] {
+				t.Errorf("rate %d, frame %d: got %v, want %v", test.rate, frame, got, test.wants[frame])
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package link
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Messages sent through the cable. Every message is 2 bytes: its kind and the byte transferred.
+const (
+	// The sender has clocked a transfer with its internal clock and waits for a reply.
+	msgTransfer = 'T'
+	// Reply to a transfer, with the byte the other end had in SB.
+	msgReply = 'R'
+)
+
+// How long a Game Boy waits for the other one to reply before giving up, as if nothing was connected.
+// Until a reply arrives again, the next transfers don't wait at all, so that a Game Boy that stopped replying
+// only freezes the other one once.
+var replyTimeout = time.Second
+
+// How many messages of each kind can wait to be handled. Older ones are dropped to make room for new ones.
+const queueLength = 16
+
+// Cable connects two Game Boys through any connection, usually TCP.
+//
+// The one that clocks a transfer sends its byte and waits for the other one's, which replies as soon as it steps.
+// If that one wasn't ready for a transfer with an external clock, it replies 0xFF and ignores the byte.
+// If both clock a transfer at the same time, each one takes the other's byte, since neither can reply.
+type Cable struct {
+	conn       net.Conn
+	transfers  chan byte
+	replies    chan byte
+	unanswered bool // The last transfer timed out, and no reply has arrived since.
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+// Listen waits for another Game Boy to connect at the given address, like ":8765".
+func Listen(address string) (*Cable, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// Dial connects to another Game Boy listening at the given address, like "localhost:8765".
+func Dial(address string) (*Cable, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// New plugs a cable to an already open connection.
+func New(conn net.Conn) *Cable {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// Every message is tiny and waited for, don't let them wait to be grouped.
+		_ = tcp.SetNoDelay(true)
+	}
+	c := &Cable{
+		conn:      conn,
+		transfers: make(chan byte, queueLength),
+		replies:   make(chan byte, queueLength),
+		closed:    make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Read messages from the other end until the connection is closed.
+// It never waits for the Game Boy to handle them, so replies always get through even if transfers pile up.
+func (c *Cable) read() {
+	defer c.Close()
+	msg := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(c.conn, msg); err != nil {
+			return
+		}
+		switch msg[0] {
+		case msgTransfer:
+			select {
+			case c.transfers <- msg[1]:
+			default:
+				// The Game Boy hasn't stepped for a while. Answer the oldest transfer as if it wasn't ready for it,
+				// so that the other one doesn't wait in vain.
+				select {
+				case <-c.transfers:
+					if err := c.send(msgReply, 0xFF); err != nil {
+						return
+					}
+				default:
+				}
+				c.transfers <- msg[1]
+			}
+		case msgReply:
+			select {
+			case c.replies <- msg[1]:
+			default:
+				// Only the last reply is ever waited for.
+				select {
+				case <-c.replies:
+				default:
+				}
+				c.replies <- msg[1]
+			}
+		}
+	}
+}
+
+func (c *Cable) send(kind, data byte) error {
+	_, err := c.conn.Write([]byte{kind, data})
+	return err
+}
+
+// Exchange sends the byte of a transfer clocked by this Game Boy and waits for the other one's.
+func (c *Cable) Exchange(out byte) byte {
+	// Forget about replies that arrived too late for a previous transfer. They show the other end is back, though.
+	for len(c.replies) > 0 {
+		<-c.replies
+		c.unanswered = false
+	}
+	if err := c.send(msgTransfer, out); err != nil {
+		return 0xFF
+	}
+	if c.unanswered {
+		select {
+		case in := <-c.transfers:
+			return in
+		default:
+			return 0xFF
+		}
+	}
+	select {
+	case in := <-c.replies:
+		return in
+	case in := <-c.transfers:
+		// Both clocked a transfer at the same time.
+		return in
+	case <-c.closed:
+		return 0xFF
+	case <-time.After(replyTimeout):
+		c.unanswered = true
+		return 0xFF
+	}
+}
+
+// Clock checks whether the other Game Boy has clocked a transfer, and replies to it.
+func (c *Cable) Clock(sb byte, ready bool) (byte, bool) {
+	select {
+	case in := <-c.transfers:
+		if !ready {
+			sb = 0xFF
+		}
+		if err := c.send(msgReply, sb); err != nil {
+			return 0xFF, false
+		}
+		return in, true
+	default:
+		return 0xFF, false
+	}
+}
+
+// Close unplugs the cable.
+func (c *Cable) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,162 @@
+package link
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Two Game Boys connected to each other.
+func connect(t *testing.T) (*Cable, *Cable) {
+	a, b := net.Pipe()
+	master, slave := New(a), New(b)
+	t.Cleanup(func() {
+		master.Close()
+		slave.Close()
+	})
+	return master, slave
+}
+
+// Step the slave until the master's transfer arrives, like the serial port does on every step.
+func clockUntilTransfer(slave *Cable, sb byte, ready bool) chan byte {
+	received := make(chan byte, 1)
+	go func() {
+		for {
+			if in, clocked := slave.Clock(sb, ready); clocked {
+				received <- in
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return received
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name       string
+		out, sb    byte
+		ready      bool
+		masterGets byte
+	}{
+		{"ready", 0x12, 0x34, true, 0x34},
+		{"not ready", 0x56, 0x78, false, 0xFF},
+		{"zero", 0x00, 0x00, true, 0x00},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			master, slave := connect(t)
+			received := clockUntilTransfer(slave, test.sb, test.ready)
+			if in := master.Exchange(test.out); in != test.masterGets {
+				t.Errorf("master got %02X, want %02X", in, test.masterGets)
+			}
+			if in := <-received; in != test.out {
+				t.Errorf("slave got %02X, want %02X", in, test.out)
+			}
+		})
+	}
+}
+
+func TestExchangeSeveralBytes(t *testing.T) {
+	master, slave := connect(t)
+	for i := 0; i < 50; i++ {
+		received := clockUntilTransfer(slave, byte(0xFF-i), true)
+		if in := master.Exchange(byte(i)); in != byte(0xFF-i) {
+			t.Fatalf("byte %d: master got %02X", i, in)
+		}
+		if in := <-received; in != byte(i) {
+			t.Fatalf("byte %d: slave got %02X", i, in)
+		}
+	}
+}
+
+func TestBothClocking(t *testing.T) {
+	a, b := connect(t)
+	fromA := make(chan byte)
+	go func() {
+		fromA <- b.Exchange(0xBB)
+	}()
+	if in := a.Exchange(0xAA); in != 0xBB {
+		t.Errorf("a got %02X, want BB", in)
+	}
+	if in := <-fromA; in != 0xAA {
+		t.Errorf("b got %02X, want AA", in)
+	}
+}
+
+func TestNoReply(t *testing.T) {
+	defer func(timeout time.Duration) { replyTimeout = timeout }(replyTimeout)
+	replyTimeout = 50 * time.Millisecond
+	a, b := net.Pipe()
+	master := New(a)
+	defer master.Close()
+	// The other end reads the transfers but never replies.
+	go io.Copy(io.Discard, b)
+
+	start := time.Now()
+	if in := master.Exchange(0x01); in != 0xFF {
+		t.Errorf("got %02X without a reply, want FF", in)
+	}
+	if elapsed := time.Since(start); elapsed < replyTimeout {
+		t.Errorf("gave up after %v, before the timeout", elapsed)
+	}
+	// Once the other end has stopped replying, it isn't waited for again.
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		if in := master.Exchange(0x02); in != 0xFF {
+			t.Errorf("got %02X without a reply, want FF", in)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= replyTimeout {
+		t.Errorf("10 more transfers took %v", elapsed)
+	}
+	// Until it replies again.
+	if _, err := b.Write([]byte{msgReply, 0x42}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.Write([]byte{msgReply, 0x43})
+	}()
+	if in := master.Exchange(0x03); in != 0x43 {
+		t.Errorf("got %02X, want the reply 43", in)
+	}
+}
+
+func TestStaleTransfers(t *testing.T) {
+	a, b := net.Pipe()
+	cable := New(a)
+	defer cable.Close()
+	replies := make(chan []byte, 100)
+	go func() {
+		for {
+			msg := make([]byte, 2)
+			if _, err := io.ReadFull(b, msg); err != nil {
+				close(replies)
+				return
+			}
+			replies <- msg
+		}
+	}()
+	// More transfers than fit in the queue, while this Game Boy doesn't step.
+	const sent = queueLength + 5
+	for i := 0; i < sent; i++ {
+		if _, err := b.Write([]byte{msgTransfer, byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest ones are answered as if it wasn't ready, and a reply still gets through after them.
+	for i := 0; i < sent-queueLength; i++ {
+		if msg := <-replies; msg[0] != msgReply || msg[1] != 0xFF {
+			t.Errorf("stale transfer %d: got reply %q, want FF", i, msg)
+		}
+	}
+	if in, clocked := cable.Clock(0x77, true); !clocked || in != byte(sent-queueLength) {
+		t.Errorf("got %02X, %v, want the oldest transfer kept, %02X", in, clocked, sent-queueLength)
+	}
+	if msg := <-replies; msg[0] != msgReply || msg[1] != 0x77 {
+		t.Errorf("got reply %q, want 77", msg)
+	}
+}
@@ -2,9 +2,9 @@ package memory
 
 import (
 	"fmt"
-	"os"
-
-	"github.com/hajimehoshi/ebiten/v2"
+	"go-boy/internal/joypad"
+	"go-boy/internal/state"
+	"io"
 )
 
 // These represent the three different states of input handling:
@@ -15,10 +15,45 @@ const (
 	P15
 )
 
+// IODevice is a device mapped to some I/O registers, like the sound controller.
+// Its registers are still kept in IOPorts, but it decides what's stored in them and what's read from them.
+type IODevice interface {
+	// Store handles a write of n at address, storing whatever it has to in IOPorts.
+	Store(m *Memory, address uint16, n byte)
+	// Read returns what's read at address.
+	Read(m *Memory, address uint16) byte
+}
+
+// Watcher is told about reads and writes, to watch what happens to some addresses.
+type Watcher interface {
+	// Read is called after value is read from address.
+	Read(address uint16, value byte)
+	// Write is called before n is stored at address, where old is.
+	Write(address uint16, old, n byte)
+}
+
+// Watchers tells several watchers about the same reads and writes.
+type Watchers []Watcher
+
+func (w Watchers) Read(address uint16, value byte) {
+	for _, watcher := range w {
+		watcher.Read(address, value)
+	}
+}
+
+func (w Watchers) Write(address uint16, old, n byte) {
+	for _, watcher := range w {
+		watcher.Write(address, old, n)
+	}
+}
+
 // Memory represents the different parts of the GB memory.
 // It's been split in different parts only to help understand it better.
 type Memory struct {
 	InputMode   int
+	Joypad      joypad.State // Buttons held down during the current frame.
+	Sound       IODevice     // Sound controller, mapped to FF10 - FF3F.
+	Watch       Watcher      // If set, it's told about every read and write.
 	IME         bool
 	IMEReqType  bool
 	IMESteps    byte
@@ -35,7 +70,7 @@ type Memory struct {
 	Cartridge   []byte // 0000 - 7FFF
 }
 
-func GetInitializedMemory(gameFile *os.File) *Memory {
+func GetInitializedMemory(gameFile io.ReaderAt) *Memory {
 	m := new(Memory)
 	m.IER = make([]byte, 1)
 	m.InternalRAM = make([]byte, 0x7F)
@@ -126,6 +161,9 @@ func (m *Memory) getMemoryPart(address uint16) (*[]byte, uint16) {
 
 // Store stores a byte in an address of the memory.
 func (m *Memory) Store(address uint16, n byte) {
+	if m.Watch != nil {
+		m.Watch.Write(address, m.read(address), n)
+	}
 	if address < 0x8000 {
 		// Bank switching would go here, but we ain't doing this yet.
 	} else if address == 0xFF00 {
@@ -137,6 +175,8 @@ func (m *Memory) Store(address uint16, n byte) {
 		} else {
 			m.InputMode = P00
 		}
+	} else if m.Sound != nil && address >= 0xFF10 && address < 0xFF40 {
+		m.Sound.Store(m, address, n)
 	} else {
 		memoryPart, offset := m.getMemoryPart(address)
 		if memoryPart == nil {
@@ -147,8 +187,18 @@ func (m *Memory) Store(address uint16, n byte) {
 }
 
 func (m *Memory) Read(address uint16) byte {
+	value := m.read(address)
+	if m.Watch != nil {
+		m.Watch.Read(address, value)
+	}
+	return value
+}
+
+func (m *Memory) read(address uint16) byte {
 	if address == 0xFF00 {
 		return m.getUserInput()
+	} else if m.Sound != nil && address >= 0xFF10 && address < 0xFF40 {
+		return m.Sound.Read(m, address)
 	} else {
 		memoryPart, offset := m.getMemoryPart(address)
 		if memoryPart == nil {
@@ -158,50 +208,37 @@ func (m *Memory) Read(address uint16) byte {
 	}
 }
 
+// ROMBank returns the bank of the cartridge switched in at 4000 - 7FFF.
+// There's no bank switching yet, so it's always 1.
+func (m *Memory) ROMBank() int {
+	return 1
+}
+
 func (m *Memory) ReadInstruction(address uint16) []byte {
 	return []byte{m.Read(address), m.Read(address + 1), m.Read(address + 2)}
 }
 
-// Processes the user's input
+// Processes the user's input.
+// The buttons pressed are stored in Joypad at the start of every frame, here they're only
+// translated to what the Game Boy expects: the pressed buttons of the selected group as 0s.
 func (m *Memory) getUserInput() byte {
-	capturedInput := byte(0x00)
 	if m.InputMode == P14 {
-		if ebiten.IsKeyPressed(ebiten.KeyZ) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonRightBottom) {
-			// A
-			capturedInput |= 0x01
-		}
-		if ebiten.IsKeyPressed(ebiten.KeyX) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonRightRight) {
-			// B
-			capturedInput |= 0x02
-		}
-		if ebiten.IsKeyPressed(ebiten.KeyBackspace) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonCenterLeft) {
-			// Select
-			capturedInput |= 0x04
-		}
-		if ebiten.IsKeyPressed(ebiten.KeyEnter) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonCenterRight) {
-			// Start
-			capturedInput |= 0x08
-		}
-		return ^capturedInput
+		return ^m.Joypad.Buttons()
 	} else if m.InputMode == P15 {
-		if ebiten.IsKeyPressed(ebiten.KeyArrowRight) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonLeftRight) {
-			// Right
-			capturedInput |= 0x01
-		}
-		if ebiten.IsKeyPressed(ebiten.KeyArrowLeft) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonLeftLeft) {
-			// Left
-			capturedInput |= 0x02
-		}
-		if ebiten.IsKeyPressed(ebiten.KeyArrowUp) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonLeftTop) {
-			// Up
-			capturedInput |= 0x04
-		}
-		if ebiten.IsKeyPressed(ebiten.KeyArrowDown) || ebiten.IsStandardGamepadButtonPressed(0, ebiten.StandardGamepadButtonLeftBottom) {
-			// Down
-			capturedInput |= 0x08
-		}
-		return ^capturedInput
+		return ^m.Joypad.Directions()
 	} else {
 		return 0xCF
 	}
 }
+
+// SaveState writes every part of the memory that can change, plus the input mode and the interrupts master enable.
+// The cartridge is left out, since it can't be written.
+func (m *Memory) SaveState(e *state.Encoder) {
+	e.Write(m.InputMode, m.Joypad, m.IME, m.IMEReqType, m.IMESteps)
+	e.Write(m.IER, m.InternalRAM, m.UnusableIO2, m.IOPorts, m.UnusableIO1, m.OAM, m.EchoRAM, m.RAM, m.SRAM, m.VRAM)
+}
+
+func (m *Memory) LoadState(d *state.Decoder) {
+	d.Read(&m.InputMode, &m.Joypad, &m.IME, &m.IMEReqType, &m.IMESteps)
+	d.Read(m.IER, m.InternalRAM, m.UnusableIO2, m.IOPorts, m.UnusableIO1, m.OAM, m.EchoRAM, m.RAM, m.SRAM, m.VRAM)
+}
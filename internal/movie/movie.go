@@ -0,0 +1,199 @@
+package movie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go-boy/internal/joypad"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// A movie file is a header followed by the joypad state of every frame, one byte each, in order.
+// The header tells how the movie starts and which game it belongs to:
+//
+//	Magic       [4]byte "GBMV"
+//	Version     byte
+//	Start       byte     one of the Start* constants
+//	ROMChecksum uint32   CRC32 of the cartridge, little endian
+//
+// Movies that start from a save state have it right after the header, as its length (uint32, little endian)
+// followed by the state itself.
+// Since the emulator only depends on its input, playing the frames back from the same start gives
+// exactly the same run.
+var magic = [4]byte{'G', 'B', 'M', 'V'}
+
+// Version of the movie files written by this emulator.
+const Version = 1
+
+// How a movie starts.
+const (
+	// The movie starts right after turning the Game Boy on.
+	StartPowerOn = iota
+	// The movie starts from the save state stored in it.
+	StartSaveState
+)
+
+// StateLoader is whatever a save state can be loaded into, like the game.
+type StateLoader interface {
+	LoadState(r io.Reader) error
+}
+
+type header struct {
+	Magic       [4]byte
+	Version     byte
+	Start       byte
+	ROMChecksum uint32
+}
+
+// Checksum returns the checksum of a cartridge stored in movies, to tell whether they belong to it.
+func Checksum(cartridge []byte) uint32 {
+	return crc32.ChecksumIEEE(cartridge)
+}
+
+// Recorder records the state of a joypad source in a movie while passing it on.
+type Recorder struct {
+	Source joypad.Source
+	file   *os.File
+	w      *bufio.Writer
+	err    error
+}
+
+// Record creates a movie file and starts recording the given source into it. It starts from power on
+// or, if state isn't nil, from that save state, which has to be the one the game is in right now.
+func Record(filename string, source joypad.Source, cartridge []byte, state []byte) (*Recorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{Source: source, file: file, w: bufio.NewWriter(file)}
+	h := header{Magic: magic, Version: Version, Start: StartPowerOn, ROMChecksum: Checksum(cartridge)}
+	if state != nil {
+		h.Start = StartSaveState
+	}
+	err = binary.Write(r.w, binary.LittleEndian, h)
+	if err == nil && state != nil {
+		if err = binary.Write(r.w, binary.LittleEndian, uint32(len(state))); err == nil {
+			_, err = r.w.Write(state)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Poll reads the state of the source and records it.
+func (r *Recorder) Poll() joypad.State {
+	state := r.Source.Poll()
+	if r.err == nil {
+		r.err = r.w.WriteByte(byte(state))
+	}
+	return state
+}
+
+// Close writes whatever is left of the movie and closes its file.
+// It also reports any error that happened while recording.
+func (r *Recorder) Close() error {
+	if err := r.w.Flush(); r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Player plays back the frames of a movie as a joypad source.
+// Once it's over, it passes on the state of Then, if any, so that a game can go on after a movie.
+type Player struct {
+	Then   joypad.Source
+	frames []byte
+	frame  int
+	state  []byte
+}
+
+// Load reads a whole movie file, checking that it belongs to the given cartridge.
+// If the movie starts from a save state, it's loaded into machine, so that it's ready to be played back.
+func Load(filename string, cartridge []byte, machine StateLoader) (*Player, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+	var h header
+	if err = binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("%s: not a movie file", filename)
+	}
+	if h.Magic != magic {
+		return nil, fmt.Errorf("%s: not a movie file", filename)
+	}
+	if h.Version != Version {
+		return nil, fmt.Errorf("%s: unsupported movie version %d", filename, h.Version)
+	}
+	if h.Start != StartPowerOn && h.Start != StartSaveState {
+		return nil, fmt.Errorf("%s: unsupported movie start %d", filename, h.Start)
+	}
+	if h.ROMChecksum != Checksum(cartridge) {
+		return nil, fmt.Errorf("%s: the movie was recorded with a different game", filename)
+	}
+	p := &Player{}
+	if h.Start == StartSaveState {
+		var length uint32
+		if err = binary.Read(r, binary.LittleEndian, &length); err != nil || int64(length) > int64(r.Len()) {
+			return nil, fmt.Errorf("%s: the save state of the movie is cut short", filename)
+		}
+		p.state = make([]byte, length)
+		if _, err = io.ReadFull(r, p.state); err != nil {
+			return nil, err
+		}
+		if err = machine.LoadState(bytes.NewReader(p.state)); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	if p.frames, err = io.ReadAll(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// State returns the save state the movie starts from, or nil if it starts from power on.
+func (p *Player) State() []byte {
+	return p.state
+}
+
+// Poll returns the state recorded for the current frame and moves on to the next one.
+func (p *Player) Poll() joypad.State {
+	if p.frame < len(p.frames) {
+		state := joypad.State(p.frames[p.frame])
+		p.frame++
+		return state
+	}
+	if p.Then != nil {
+		return p.Then.Poll()
+	}
+	return 0
+}
+
+// Position returns how many frames have been played.
+func (p *Player) Position() int {
+	return p.frame
+}
+
+// Seek makes the next Poll return the given frame.
+func (p *Player) Seek(frame int) {
+	p.frame = frame
+}
+
+// Frames returns how many frames the movie has.
+func (p *Player) Frames() int {
+	return len(p.frames)
+}
+
+// Done tells whether all the frames of the movie have been played.
+func (p *Player) Done() bool {
+	return p.frame >= len(p.frames)
+}
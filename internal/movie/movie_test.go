@@ -0,0 +1,155 @@
+package movie
+
+import (
+	"bytes"
+	"errors"
+	"go-boy/internal/joypad"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Plays some states, and then nothing.
+type script []joypad.State
+
+func (s *script) Poll() joypad.State {
+	if len(*s) == 0 {
+		return 0
+	}
+	state := (*s)[0]
+	*s = (*s)[1:]
+	return state
+}
+
+// Keeps the save state loaded into it.
+type machine struct {
+	loaded []byte
+	err    error
+}
+
+func (m *machine) LoadState(r io.Reader) error {
+	if m.err != nil {
+		return m.err
+	}
+	var err error
+	m.loaded, err = io.ReadAll(r)
+	return err
+}
+
+func TestRecordAndPlay(t *testing.T) {
+	cartridge := []byte("a game")
+	frames := []joypad.State{joypad.A, 0, joypad.Up | joypad.B, joypad.Start}
+	tests := []struct {
+		name  string
+		state []byte
+	}{
+		{"power on", nil},
+		{"save state", []byte("GBST a state")},
+		{"empty save state", []byte{}},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "test.gbm")
+		source := script(frames)
+		r, err := Record(filename, &source, cartridge, test.state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range frames {
+			if got := r.Poll(); got != want {
+				t.Errorf("%s: recording returned %v instead of %v", test.name, got, want)
+			}
+		}
+		if err = r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		m := &machine{}
+		p, err := Load(filename, cartridge, m)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.state == nil && (p.State() != nil || m.loaded != nil) {
+			t.Errorf("%s: got a save state in a movie from power on", test.name)
+		} else if test.state != nil && (!bytes.Equal(p.State(), test.state) || !bytes.Equal(m.loaded, test.state)) {
+			t.Errorf("%s: got save state %q, loaded %q, want %q", test.name, p.State(), m.loaded, test.state)
+		}
+		if p.Frames() != len(frames) {
+			t.Errorf("%s: got %d frames, want %d", test.name, p.Frames(), len(frames))
+		}
+		// Once over, the movie passes on what Then polls.
+		p.Then = &script{joypad.Select}
+		for i, want := range append(frames, joypad.Select, 0) {
+			if got := p.Poll(); got != want {
+				t.Errorf("%s: frame %d: got %v, want %v", test.name, i, got, want)
+			}
+		}
+		if !p.Done() {
+			t.Errorf("%s: not done after all the frames", test.name)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	p := &Player{frames: []byte{1, 2, 3, 4}}
+	p.Poll()
+	p.Poll()
+	if p.Position() != 2 {
+		t.Errorf("got position %d after 2 frames", p.Position())
+	}
+	p.Seek(1)
+	if got := p.Poll(); got != 2 || p.Position() != 2 {
+		t.Errorf("got %v at position %d after seeking to frame 1", got, p.Position())
+	}
+	p.Seek(4)
+	if !p.Done() || p.Poll() != 0 {
+		t.Errorf("not over after seeking to the end")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cartridge := []byte("a game")
+	dir := t.TempDir()
+	record := func(state []byte) []byte {
+		filename := filepath.Join(dir, "ok.gbm")
+		source := script{joypad.A}
+		r, err := Record(filename, &source, cartridge, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Poll()
+		if err = r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	powerOn := record(nil)
+	saveState := record([]byte("state"))
+	tests := []struct {
+		name      string
+		data      []byte
+		cartridge []byte
+		loadErr   error
+	}{
+		{"not a movie", []byte("GBST0000000000"), cartridge, nil},
+		{"cut short", powerOn[:5], cartridge, nil},
+		{"newer version", append(append([]byte("GBMV"), Version+1), powerOn[5:]...), cartridge, nil},
+		{"unknown start", append(append([]byte{}, powerOn[:5]...), append([]byte{9}, powerOn[6:]...)...), cartridge, nil},
+		{"another game", powerOn, []byte("another game"), nil},
+		{"save state cut short", saveState[:len(saveState)-3], cartridge, nil},
+		{"broken save state", saveState, cartridge, errors.New("broken")},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, "test.gbm")
+		if err := os.WriteFile(filename, test.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(filename, test.cartridge, &machine{err: test.loadErr}); err == nil {
+			t.Errorf("%s: loaded without errors", test.name)
+		}
+	}
+}
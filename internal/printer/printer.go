@@ -0,0 +1,272 @@
+package printer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Commands the Game Boy sends to the printer.
+const (
+	cmdInit   = 0x01
+	cmdPrint  = 0x02
+	cmdData   = 0x04
+	cmdStatus = 0x0F
+)
+
+// Bits of the status byte the printer replies at the end of every packet.
+const (
+	statusChecksumError = 0x01
+	statusPrinting      = 0x02
+	statusUnprocessed   = 0x08
+)
+
+// Parts of a packet, in the order they arrive.
+const (
+	stateMagic1 = iota
+	stateMagic2
+	stateCommand
+	stateCompression
+	stateLengthLow
+	stateLengthHigh
+	stateData
+	stateChecksumLow
+	stateChecksumHigh
+	stateAlive
+	stateStatus
+)
+
+// The printer prints 160 pixels wide, 20 tiles. Every data packet has 2 rows of them.
+const (
+	tilesPerRow = 20
+	paperWidth  = tilesPerRow * 8
+)
+
+// The 4 shades of the thermal paper, from lighter to darker.
+var shades = [4]color.Gray{{0xFF}, {0xAA}, {0x55}, {0x00}}
+
+// Printer emulates a Game Boy Printer plugged to the serial port.
+// It receives packets made of:
+//
+//	0x88 0x33 | command | compression | length (2 bytes, LSB first) | data | checksum (2 bytes, LSB first) | 0x00 0x00
+//
+// replying 0x00 to everything but the last two bytes, when it replies 0x81 to say it's alive and then its status.
+// The data packets fill its buffer with tiles, and the print command prints them on the paper. Games print long
+// pictures a few rows at a time, so the paper is only saved as a PNG image once a print leaves a margin after it.
+type Printer struct {
+	// Every printout is saved as Prefix-N.png, with the first N that's not taken.
+	Prefix string
+
+	state       int
+	command     byte
+	compressed  bool
+	length      int
+	data        []byte
+	checksum    uint16
+	received    uint16
+	status      byte
+	buffer      []byte // Tile data waiting to be printed.
+	paper       []byte // Shades of the pixels printed since the last margin, a row of 160 after another.
+	printouts   int
+	printingFor int // Status replies left before the printout is done.
+}
+
+// NewPrinter returns a printer that saves the printouts next to the given ROM file,
+// as game-print-1.png, game-print-2.png...
+func NewPrinter(romFilename string) *Printer {
+	prefix := strings.TrimSuffix(romFilename, filepath.Ext(romFilename))
+	return &Printer{Prefix: prefix + "-print"}
+}
+
+// Exchange receives one byte of a packet and returns the printer's reply.
+func (p *Printer) Exchange(out byte) byte {
+	reply := byte(0x00)
+	switch p.state {
+	case stateMagic1:
+		if out == 0x88 {
+			p.state = stateMagic2
+		}
+	case stateMagic2:
+		if out == 0x33 {
+			p.state = stateCommand
+		} else {
+			p.state = stateMagic1
+		}
+	case stateCommand:
+		p.command = out
+		p.checksum = uint16(out)
+		p.state = stateCompression
+	case stateCompression:
+		p.compressed = out&0x01 != 0
+		p.checksum += uint16(out)
+		p.state = stateLengthLow
+	case stateLengthLow:
+		p.length = int(out)
+		p.checksum += uint16(out)
+		p.state = stateLengthHigh
+	case stateLengthHigh:
+		p.length |= int(out) << 8
+		p.checksum += uint16(out)
+		p.data = p.data[:0]
+		if p.length == 0 {
+			p.state = stateChecksumLow
+		} else {
+			p.state = stateData
+		}
+	case stateData:
+		p.data = append(p.data, out)
+		p.checksum += uint16(out)
+		if len(p.data) == p.length {
+			p.state = stateChecksumLow
+		}
+	case stateChecksumLow:
+		p.received = uint16(out)
+		p.state = stateChecksumHigh
+	case stateChecksumHigh:
+		p.received |= uint16(out) << 8
+		p.state = stateAlive
+	case stateAlive:
+		reply = 0x81
+		p.state = stateStatus
+	case stateStatus:
+		// The packet is over. Run its command before replying with the status.
+		if p.received != p.checksum {
+			p.status |= statusChecksumError
+		} else {
+			p.status &^= statusChecksumError
+			p.run()
+		}
+		reply = p.status
+		p.state = stateMagic1
+	}
+	return reply
+}
+
+// Run the command of the packet that has just been received.
+func (p *Printer) run() {
+	switch p.command {
+	case cmdInit:
+		p.buffer = p.buffer[:0]
+		p.status = 0
+		p.printingFor = 0
+	case cmdData:
+		if p.compressed {
+			p.buffer = append(p.buffer, decompress(p.data)...)
+		} else {
+			p.buffer = append(p.buffer, p.data...)
+		}
+		if len(p.buffer) > 0 {
+			p.status |= statusUnprocessed
+		}
+	case cmdPrint:
+		if len(p.data) < 4 {
+			return
+		}
+		// Print data: number of sheets, margins, palette and exposure. The margins are the lines fed before (high
+		// nibble) and after (low nibble) printing. A margin after it means the printout is over and can be torn off.
+		margins, palette := p.data[1], p.data[2]
+		p.print(palette)
+		if margins&0x0F != 0 {
+			if err := p.save(); err != nil {
+				fmt.Fprintln(os.Stderr, "printer:", err)
+			}
+		}
+		p.buffer = p.buffer[:0]
+		p.status = (p.status &^ statusUnprocessed) | statusPrinting
+		// Games wait until the printer stops printing, so let them see it's busy for a couple of replies.
+		p.printingFor = 2
+	case cmdStatus:
+		if p.printingFor > 0 {
+			p.printingFor--
+			if p.printingFor == 0 {
+				p.status &^= statusPrinting
+			}
+		}
+	}
+}
+
+// RLE decompression. Every run starts with a control byte: if its MSB is set, the next byte is repeated
+// (control & 0x7F) + 2 times. Otherwise, the next (control + 1) bytes are copied as they are.
+func decompress(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+		if control&0x80 != 0 {
+			if i >= len(data) {
+				break
+			}
+			for n := 0; n < int(control&0x7F)+2; n++ {
+				out = append(out, data[i])
+			}
+			i++
+		} else {
+			n := int(control) + 1
+			if i+n > len(data) {
+				n = len(data) - i
+			}
+			out = append(out, data[i:i+n]...)
+			i += n
+		}
+	}
+	return out
+}
+
+// Print the tiles in the buffer on the paper, using the given palette.
+func (p *Printer) print(palette byte) {
+	rows := len(p.buffer) / (tilesPerRow * 16)
+	// A palette of 0 is the same as the default one.
+	if palette == 0 {
+		palette = 0xE4
+	}
+	top := len(p.paper)
+	p.paper = append(p.paper, make([]byte, rows*8*paperWidth)...)
+	for tile := 0; tile < rows*tilesPerRow; tile++ {
+		tileX := tile % tilesPerRow * 8
+		tileY := tile / tilesPerRow * 8
+		for y := 0; y < 8; y++ {
+			// Same as in the screen: the first byte has the LSB of each pixel, the second one the MSB.
+			lsb := p.buffer[tile*16+2*y]
+			msb := p.buffer[tile*16+2*y+1]
+			for x := 0; x < 8; x++ {
+				colorNumber := (msb>>(7-x)&0x01)<<1 | lsb>>(7-x)&0x01
+				p.paper[top+(tileY+y)*paperWidth+tileX+x] = palette >> (2 * colorNumber) & 0x03
+			}
+		}
+	}
+}
+
+// Save what has been printed on the paper as a PNG image, and start a new one.
+func (p *Printer) save() error {
+	if len(p.paper) == 0 {
+		return nil
+	}
+	img := image.NewGray(image.Rect(0, 0, paperWidth, len(p.paper)/paperWidth))
+	for i, shade := range p.paper {
+		img.SetGray(i%paperWidth, i/paperWidth, shades[shade])
+	}
+	p.paper = p.paper[:0]
+
+	// Find the first file name that's not taken.
+	var filename string
+	for {
+		p.printouts++
+		filename = fmt.Sprintf("%s-%d.png", p.Prefix, p.printouts)
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			break
+		}
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
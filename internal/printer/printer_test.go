@@ -0,0 +1,144 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Send a whole packet to the printer, with the right checksum unless it's broken, and return its replies.
+func send(p *Printer, command byte, compressed bool, data []byte, broken bool) []byte {
+	compression := byte(0)
+	if compressed {
+		compression = 1
+	}
+	body := append([]byte{command, compression, byte(len(data)), byte(len(data) >> 8)}, data...)
+	var checksum uint16
+	for _, b := range body {
+		checksum += uint16(b)
+	}
+	if broken {
+		checksum++
+	}
+	packet := append([]byte{0x88, 0x33}, body...)
+	packet = append(packet, byte(checksum), byte(checksum>>8), 0, 0)
+	replies := make([]byte, len(packet))
+	for i, b := range packet {
+		replies[i] = p.Exchange(b)
+	}
+	return replies
+}
+
+func TestPackets(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  byte
+		data     []byte
+		broken   bool
+		status   byte
+		buffered int
+	}{
+		{"init", cmdInit, nil, false, 0, 0},
+		{"status", cmdStatus, nil, false, 0, 0},
+		{"data", cmdData, make([]byte, 0x280), false, statusUnprocessed, 0x280},
+		{"empty data", cmdData, nil, false, statusUnprocessed, 0x280},
+		{"broken checksum", cmdData, make([]byte, 0x10), true, statusUnprocessed | statusChecksumError, 0x280},
+		{"fixed checksum", cmdData, make([]byte, 0x10), false, statusUnprocessed, 0x290},
+		{"init again", cmdInit, nil, false, 0, 0},
+	}
+	p := &Printer{Prefix: filepath.Join(t.TempDir(), "print")}
+	for _, test := range tests {
+		replies := send(p, test.command, false, test.data, test.broken)
+		// Everything is answered with 0, but the last two bytes.
+		for i, reply := range replies[:len(replies)-2] {
+			if reply != 0 {
+				t.Errorf("%s: reply %02X to byte %d", test.name, reply, i)
+			}
+		}
+		if alive := replies[len(replies)-2]; alive != 0x81 {
+			t.Errorf("%s: got %02X instead of 81 for alive", test.name, alive)
+		}
+		if status := replies[len(replies)-1]; status != test.status {
+			t.Errorf("%s: got status %02X, want %02X", test.name, status, test.status)
+		}
+		if len(p.buffer) != test.buffered {
+			t.Errorf("%s: %d bytes in the buffer, want %d", test.name, len(p.buffer), test.buffered)
+		}
+	}
+}
+
+func TestGarbageBeforePacket(t *testing.T) {
+	p := &Printer{}
+	// Anything before the magic bytes, or a 0x88 not followed by 0x33, is ignored.
+	for _, b := range []byte{0x00, 0xFF, 0x88, 0x00, 0x33} {
+		if reply := p.Exchange(b); reply != 0 {
+			t.Errorf("got %02X for %02X outside a packet", reply, b)
+		}
+	}
+	if replies := send(p, cmdStatus, false, nil, false); replies[len(replies)-2] != 0x81 {
+		t.Errorf("the packet after the garbage wasn't received")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name       string
+		data, want []byte
+	}{
+		{"empty", nil, nil},
+		{"copied", []byte{0x02, 1, 2, 3}, []byte{1, 2, 3}},
+		{"repeated", []byte{0x81, 7}, []byte{7, 7, 7}},
+		{"shortest run", []byte{0x80, 9}, []byte{9, 9}},
+		{"longest run", []byte{0xFF, 5}, bytes.Repeat([]byte{5}, 129)},
+		{"mixed", []byte{0x00, 1, 0x82, 2, 0x01, 3, 4}, []byte{1, 2, 2, 2, 2, 3, 4}},
+		{"copy cut short", []byte{0x05, 1, 2}, []byte{1, 2}},
+		{"run cut short", []byte{0x00, 1, 0x85}, []byte{1}},
+	}
+	for _, test := range tests {
+		if got := decompress(test.data); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompressedData(t *testing.T) {
+	p := &Printer{}
+	send(p, cmdData, true, []byte{0xFF, 0xAA, 0x80, 0x55}, false)
+	if want := append(bytes.Repeat([]byte{0xAA}, 129), 0x55, 0x55); !bytes.Equal(p.buffer, want) {
+		t.Errorf("got %v in the buffer, want %v", p.buffer, want)
+	}
+}
+
+// Pictures printed in parts are saved together when one of the prints leaves a margin after it.
+func TestPrintouts(t *testing.T) {
+	dir := t.TempDir()
+	p := &Printer{Prefix: filepath.Join(dir, "print")}
+	band := make([]byte, 2*tilesPerRow*16)
+	for _, margins := range []byte{0x10, 0x00, 0x03, 0x13} {
+		send(p, cmdInit, false, nil, false)
+		send(p, cmdData, false, band, false)
+		send(p, cmdData, false, nil, false)
+		send(p, cmdPrint, false, []byte{1, margins, 0xE4, 0x40}, false)
+	}
+	heights := []int{48, 16}
+	for i, height := range heights {
+		file, err := os.Open(filepath.Join(dir, fmt.Sprintf("print-%d.png", i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size.X != 160 || size.Y != height {
+			t.Errorf("printout %d is %v, want 160x%d", i+1, size, height)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "print-3.png")); err == nil {
+		t.Errorf("more printouts than expected")
+	}
+}
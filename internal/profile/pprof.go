@@ -0,0 +1,144 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"go-boy/internal/disasm"
+	"io"
+	"time"
+)
+
+// Clock of the Game Boy, to tell how long the cycles took.
+const cyclesPerSecond = 4194304
+
+// WritePprof writes the profile in the format of pprof, so that "go tool pprof" can show it like that of any Go
+// program, with the Game Boy functions and addresses instead. symbols can be nil.
+//
+// The format is a gzipped protocol buffer, described in
+// https://github.com/google/pprof/blob/main/proto/profile.proto. Only the bits needed here are written, by hand,
+// to keep the emulator free of dependencies for it.
+func (p *Profiler) WritePprof(w io.Writer, symbols *disasm.Symbols) error {
+	names := namer{symbols}
+	stringIndex := map[string]uint64{"": 0}
+	var stringTable []string
+	str := func(s string) uint64 {
+		if i, ok := stringIndex[s]; ok {
+			return i
+		}
+		stringIndex[s] = uint64(len(stringTable) + 1)
+		stringTable = append(stringTable, s)
+		return stringIndex[s]
+	}
+
+	var profile protobuf
+	// Sample types and the period: every sample is a number of cycles.
+	valueType := func() []byte {
+		var t protobuf
+		t.uint(1, str("cycles"))
+		t.uint(2, str("count"))
+		return t.Bytes()
+	}
+	profile.bytes(1, valueType())
+
+	type location struct {
+		bank     int
+		address  uint16
+		function string
+	}
+	functions := make(map[string]uint64)
+	locations := make(map[location]uint64)
+	var functionMessages, locationMessages protobuf
+	p.each(func(frames []frame, cycles uint64) {
+		// Samples go from the innermost call to the outermost one.
+		ids := make([]uint64, 0, len(frames))
+		for i := len(frames) - 1; i >= 0; i-- {
+			f := frames[i]
+			l := location{f.bank, f.address, names.name(f)}
+			id, ok := locations[l]
+			if !ok {
+				function, ok := functions[l.function]
+				if !ok {
+					function = uint64(len(functions) + 1)
+					functions[l.function] = function
+					var m protobuf
+					m.uint(1, function)
+					m.uint(2, str(l.function))
+					m.uint(3, str(l.function))
+					functionMessages.bytes(5, m.Bytes())
+				}
+				id = uint64(len(locations) + 1)
+				locations[l] = id
+				var line protobuf
+				line.uint(1, function)
+				line.uint(2, uint64(f.address))
+				var m protobuf
+				m.uint(1, id)
+				m.uint(3, uint64(f.bank)<<16|uint64(f.address))
+				m.bytes(4, line.Bytes())
+				locationMessages.bytes(4, m.Bytes())
+			}
+			ids = append(ids, id)
+		}
+		var s protobuf
+		s.packed(1, ids)
+		s.packed(2, []uint64{cycles})
+		profile.bytes(2, s.Bytes())
+	})
+	profile.Write(locationMessages.Bytes())
+	profile.Write(functionMessages.Bytes())
+	// The string table goes last, once all of them are in.
+	profile.bytes(6, nil)
+	for _, s := range stringTable {
+		profile.bytes(6, []byte(s))
+	}
+	profile.uint(9, uint64(time.Now().UnixNano()))
+	profile.uint(10, uint64(gameTime(p.Total)))
+	profile.bytes(11, valueType())
+	profile.uint(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Protocol buffer message, written field by field.
+type protobuf struct {
+	bytes.Buffer
+}
+
+func (b *protobuf) varint(v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+// Integer field.
+func (b *protobuf) uint(field int, v uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+// Length delimited field: a string, bytes, an embedded message or packed integers.
+func (b *protobuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protobuf) packed(field int, values []uint64) {
+	var p protobuf
+	for _, v := range values {
+		p.varint(v)
+	}
+	b.bytes(field, p.Bytes())
+}
+
+// How long the given cycles take on a Game Boy. Multiplying them by a second in nanoseconds first would overflow
+// after about 40 minutes of play.
+func gameTime(cycles uint64) time.Duration {
+	return time.Duration(float64(cycles) / cyclesPerSecond * float64(time.Second))
+}
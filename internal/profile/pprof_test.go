@@ -0,0 +1,179 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"go-boy/internal/disasm"
+	"io"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGameTime(t *testing.T) {
+	tests := []struct {
+		cycles uint64
+		want   time.Duration
+	}{
+		{0, 0},
+		{cyclesPerSecond, time.Second},
+		{cyclesPerSecond / 4, 250 * time.Millisecond},
+		{cyclesPerSecond * 3600, time.Hour},
+		{cyclesPerSecond * 3600 * 100, 100 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := gameTime(test.cycles); got != test.want {
+			t.Errorf("gameTime(%d): got %v, want %v", test.cycles, got, test.want)
+		}
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(b *protobuf)
+		want  []byte
+	}{
+		{"small varint", func(b *protobuf) { b.varint(1) }, []byte{0x01}},
+		{"varint", func(b *protobuf) { b.varint(300) }, []byte{0xAC, 0x02}},
+		{"large varint", func(b *protobuf) { b.varint(1 << 63) }, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}},
+		{"integer", func(b *protobuf) { b.uint(2, 150) }, []byte{0x10, 0x96, 0x01}},
+		{"string", func(b *protobuf) { b.bytes(6, []byte("abc")) }, []byte{0x32, 0x03, 'a', 'b', 'c'}},
+		{"empty", func(b *protobuf) { b.bytes(6, nil) }, []byte{0x32, 0x00}},
+		{"packed", func(b *protobuf) { b.packed(1, []uint64{3, 270}) }, []byte{0x0A, 0x03, 0x03, 0x8E, 0x02}},
+	}
+	for _, test := range tests {
+		var b protobuf
+		test.write(&b)
+		if !bytes.Equal(b.Bytes(), test.want) {
+			t.Errorf("%s: got % X, want % X", test.name, b.Bytes(), test.want)
+		}
+	}
+}
+
+// A field of a protocol buffer message: an integer, or the data of a length delimited one.
+type field struct {
+	number int
+	value  uint64
+	data   []byte
+}
+
+func readVarint(t *testing.T, data []byte) (uint64, []byte) {
+	t.Helper()
+	var v uint64
+	for i, b := range data {
+		v |= uint64(b&0x7F) << (7 * i)
+		if b < 0x80 {
+			return v, data[i+1:]
+		}
+	}
+	t.Fatal("varint cut short")
+	return 0, nil
+}
+
+// Read the fields of a message, which can only be integers and length delimited ones here.
+func readMessage(t *testing.T, data []byte) []field {
+	t.Helper()
+	var fields []field
+	for len(data) > 0 {
+		var key, v uint64
+		key, data = readVarint(t, data)
+		v, data = readVarint(t, data)
+		f := field{number: int(key >> 3), value: v}
+		switch key & 7 {
+		case 0:
+		case 2:
+			if v > uint64(len(data)) {
+				t.Fatalf("field %d cut short", f.number)
+			}
+			f.data, data = data[:v], data[v:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func TestWritePprof(t *testing.T) {
+	symbols := disasm.NewSymbols()
+	symbols.Add(0, 0x0150, "Main")
+	symbols.Add(0, 0x0200, "Update")
+	p := newProfile()
+	var b bytes.Buffer
+	if err := p.WritePprof(&b, symbols); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stringTable []string
+	var samples, locations, functions [][]field
+	var duration uint64
+	for _, f := range readMessage(t, data) {
+		switch f.number {
+		case 2:
+			samples = append(samples, readMessage(t, f.data))
+		case 4:
+			locations = append(locations, readMessage(t, f.data))
+		case 5:
+			functions = append(functions, readMessage(t, f.data))
+		case 6:
+			stringTable = append(stringTable, string(f.data))
+		case 10:
+			duration = f.value
+		}
+	}
+	if len(stringTable) == 0 || stringTable[0] != "" {
+		t.Fatalf("the string table doesn't start with an empty string: %q", stringTable)
+	}
+	if duration != uint64(gameTime(p.Total)) {
+		t.Errorf("got a duration of %d, want %d", duration, gameTime(p.Total))
+	}
+
+	// Functions by ID, and the function of each location by ID.
+	names := make(map[uint64]string)
+	for _, f := range functions {
+		names[f[0].value] = stringTable[f[1].value]
+	}
+	locationFunctions := make(map[uint64]string)
+	for _, l := range locations {
+		line := readMessage(t, l[2].data)
+		locationFunctions[l[0].value] = names[line[0].value]
+	}
+	// Every sample, as its stack of functions from the innermost one, and its cycles.
+	var got []string
+	total := uint64(0)
+	for _, s := range samples {
+		var stack string
+		ids := s[0].data
+		for len(ids) > 0 {
+			var id uint64
+			id, ids = readVarint(t, ids)
+			stack += locationFunctions[id] + " "
+		}
+		cycles, _ := readVarint(t, s[1].data)
+		total += cycles
+		got = append(got, stack)
+	}
+	sort.Strings(got)
+	want := []string{"Main ", "Main ", "Update Main ", "Update Main "}
+	if len(got) != len(want) {
+		t.Fatalf("got samples %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got samples %q, want %q", got, want)
+			break
+		}
+	}
+	if total != p.Total {
+		t.Errorf("the samples add up to %d cycles, want %d", total, p.Total)
+	}
+}
@@ -0,0 +1,205 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"go-boy/internal/callstack"
+	"go-boy/internal/disasm"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A place in the code, with the function it's in. Function is the address called to get there, which names the
+// function when there are no symbols, or 0 if it's not known, like for the code run from power on.
+type frame struct {
+	bank     int
+	address  uint16
+	function uint16
+}
+
+// Cycles spent at an address, with the calls that led there.
+type sample struct {
+	stack int // Index in Profiler.stacks.
+	bank  int
+	pc    uint16
+}
+
+// Profiler counts exactly how many cycles are spent at each address, and through which calls it was reached,
+// as followed by the shadow call stack.
+type Profiler struct {
+	Total uint64 // Cycles counted.
+
+	samples map[sample]uint64
+	// Every different set of calls seen, from the outermost one, with the entry of the innermost one at the end.
+	stacks   [][]frame
+	stackIDs map[string]int
+	// Calls seen last time, to only look them up again when they change.
+	stack int
+	depth int
+	top   callstack.Frame
+}
+
+// New returns a profiler with nothing counted.
+func New() *Profiler {
+	p := &Profiler{samples: make(map[sample]uint64), stackIDs: make(map[string]int)}
+	// Without calls, everything is in the code run from power on.
+	p.stacks = [][]frame{{{function: 0}}}
+	p.stackIDs[""] = 0
+	return p
+}
+
+// Add counts the cycles taken by the instruction at pc, in the given ROM bank, reached through calls. Calls can be nil.
+func (p *Profiler) Add(bank int, pc uint16, cycles int, calls *callstack.Stack) {
+	if calls != nil {
+		// A stack with the same depth and innermost call as before is the same, since calls only come and go at the end.
+		frames := calls.Frames()
+		if len(frames) != p.depth || len(frames) > 0 && frames[len(frames)-1] != p.top {
+			p.stack = p.stackID(bank, frames)
+			p.depth = len(frames)
+			if len(frames) > 0 {
+				p.top = frames[len(frames)-1]
+			}
+		}
+	}
+	p.samples[sample{p.stack, bank, pc}] += uint64(cycles)
+	p.Total += uint64(cycles)
+}
+
+// Look up the stack for the calls, adding it if it's new. The caller of every call is in the function called before it.
+func (p *Profiler) stackID(bank int, calls []callstack.Frame) int {
+	var key strings.Builder
+	for _, c := range calls {
+		fmt.Fprintf(&key, "%04X>%04X ", c.Caller, c.Entry)
+	}
+	if id, ok := p.stackIDs[key.String()]; ok {
+		return id
+	}
+	stack := make([]frame, 0, len(calls)+1)
+	function := uint16(0)
+	for _, c := range calls {
+		stack = append(stack, frame{bank, c.Caller, function})
+		function = c.Entry
+	}
+	// The last one only tells which function the samples are in.
+	stack = append(stack, frame{function: function})
+	p.stacks = append(p.stacks, stack)
+	p.stackIDs[key.String()] = len(p.stacks) - 1
+	return len(p.stacks) - 1
+}
+
+// Reset forgets everything counted.
+func (p *Profiler) Reset() {
+	*p = *New()
+}
+
+// Names functions with the symbols if there are any, or with the address called to get into them otherwise.
+type namer struct {
+	symbols *disasm.Symbols
+}
+
+func (n namer) name(f frame) string {
+	if name, ok := n.symbols.Function(f.bank, f.address); ok {
+		return name
+	}
+	if f.function == 0 {
+		return "(start)"
+	}
+	return fmt.Sprintf("$%04X", f.function)
+}
+
+// Calls the function for every sample, with its calls from the outermost one, ending at the sample itself.
+func (p *Profiler) each(f func(frames []frame, cycles uint64)) {
+	var frames []frame
+	for s, cycles := range p.samples {
+		stack := p.stacks[s.stack]
+		frames = append(frames[:0], stack[:len(stack)-1]...)
+		frames = append(frames, frame{s.bank, s.pc, stack[len(stack)-1].function})
+		f(frames, cycles)
+	}
+}
+
+type row struct {
+	name      string
+	flat, cum uint64
+}
+
+// WriteReport writes the n functions and addresses where most cycles were spent. The cycles of a function are counted
+// as flat when they're spent in it, and as cumulative when they're spent in it or in anything it called.
+// symbols can be nil.
+func (p *Profiler) WriteReport(w io.Writer, symbols *disasm.Symbols, n int) error {
+	names := namer{symbols}
+	functions := make(map[string]*row)
+	addresses := make(map[frame]*row)
+	p.each(func(frames []frame, cycles uint64) {
+		leaf := frames[len(frames)-1]
+		seen := make(map[string]bool, len(frames))
+		for i, f := range frames {
+			name := names.name(f)
+			r := functions[name]
+			if r == nil {
+				r = &row{name: name}
+				functions[name] = r
+			}
+			if i == len(frames)-1 {
+				r.flat += cycles
+			}
+			// Recursive calls count once.
+			if !seen[name] {
+				r.cum += cycles
+				seen[name] = true
+			}
+		}
+		address := frame{bank: leaf.bank, address: leaf.address}
+		r := addresses[address]
+		if r == nil {
+			r = &row{name: fmt.Sprintf("%02X:%04X  %s", leaf.bank, leaf.address, symbols.Describe(leaf.bank, leaf.address))}
+			addresses[address] = r
+		}
+		r.flat += cycles
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d cycles (%.2f seconds of Game Boy time)\n\n", p.Total, float64(p.Total)/cyclesPerSecond)
+	fmt.Fprintf(bw, "%12s %6s %12s %6s  %s\n", "flat", "flat%", "cum", "cum%", "function")
+	for _, r := range top(functions, n) {
+		fmt.Fprintf(bw, "%12d %6s %12d %6s  %s\n", r.flat, p.percent(r.flat), r.cum, p.percent(r.cum), r.name)
+	}
+	rows := make(map[string]*row, len(addresses))
+	for _, r := range addresses {
+		rows[r.name] = r
+	}
+	fmt.Fprintf(bw, "\n%12s %6s  %s\n", "cycles", "%", "address")
+	for _, r := range top(rows, n) {
+		fmt.Fprintf(bw, "%12d %6s  %s\n", r.flat, p.percent(r.flat), r.name)
+	}
+	return bw.Flush()
+}
+
+// The n rows with the most flat cycles, and then the most cumulative ones.
+func top(rows map[string]*row, n int) []*row {
+	sorted := make([]*row, 0, len(rows))
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.flat != b.flat {
+			return a.flat > b.flat
+		} else if a.cum != b.cum {
+			return a.cum > b.cum
+		}
+		return a.name < b.name
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+func (p *Profiler) percent(cycles uint64) string {
+	if p.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", 100*float64(cycles)/float64(p.Total))
+}
@@ -0,0 +1,95 @@
+package profile
+
+import (
+	"go-boy/internal/callstack"
+	"go-boy/internal/disasm"
+	"strings"
+	"testing"
+)
+
+// Profile a function at 0200 called from 0155, in the code run from power on at 0150.
+func newProfile() *Profiler {
+	p := New()
+	calls := callstack.New()
+	p.Add(0, 0x0150, 4, calls)
+	calls.Call(0x0155, 0x0200, 0x0158, 0xDFFC)
+	p.Add(0, 0x0200, 8, calls)
+	p.Add(0, 0x0201, 12, calls)
+	calls.Return(0x0201, 0x0158, 0xDFFC)
+	p.Add(0, 0x0158, 4, calls)
+	return p
+}
+
+func TestReport(t *testing.T) {
+	symbols := disasm.NewSymbols()
+	symbols.Add(0, 0x0150, "Main")
+	symbols.Add(0, 0x0200, "Update")
+	tests := []struct {
+		name    string
+		symbols *disasm.Symbols
+		n       int
+		want    []string
+	}{
+		{"symbols", symbols, 0, []string{
+			"28 cycles (0.00 seconds of Game Boy time)",
+			"          20 71.43%           20 71.43%  Update",
+			"           8 28.57%           28 100.00%  Main",
+			"          12 42.86%  00:0201  Update+1",
+			"           8 28.57%  00:0200  Update",
+			"           4 14.29%  00:0150  Main",
+			"           4 14.29%  00:0158  Main+8",
+		}},
+		{"no symbols", nil, 0, []string{
+			"          20 71.43%           20 71.43%  $0200",
+			"           8 28.57%           28 100.00%  (start)",
+			"          12 42.86%  00:0201  $0201",
+		}},
+		{"top", symbols, 1, []string{
+			"          20 71.43%           20 71.43%  Update\n\n",
+			"          12 42.86%  00:0201  Update+1\n",
+		}},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := newProfile().WriteReport(&b, test.symbols, test.n); err != nil {
+			t.Fatal(err)
+		}
+		rest := b.String()
+		for _, want := range test.want {
+			i := strings.Index(rest, want)
+			if i < 0 {
+				t.Errorf("%s: %q not found in the report:\n%s", test.name, want, b.String())
+				break
+			}
+			rest = rest[i+len(want):]
+		}
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	p := New()
+	calls := callstack.New()
+	calls.Call(0x0150, 0x0200, 0x0153, 0xDFFC)
+	calls.Call(0x0210, 0x0200, 0x0213, 0xDFFA)
+	p.Add(0, 0x0205, 10, calls)
+	var b strings.Builder
+	if err := p.WriteReport(&b, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The cycles of a function that calls itself count once in its cumulative ones.
+	if want := "          10 100.00%           10 100.00%  $0200\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("%q not found in the report:\n%s", want, b.String())
+	}
+}
+
+func TestReset(t *testing.T) {
+	p := newProfile()
+	p.Reset()
+	var b strings.Builder
+	if err := p.WriteReport(&b, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if p.Total != 0 || !strings.HasPrefix(b.String(), "0 cycles") {
+		t.Errorf("got a report after resetting:\n%s", b.String())
+	}
+}
@@ -2,6 +2,7 @@ package registers
 
 import (
 	"fmt"
+	"go-boy/internal/state"
 )
 
 // Registers in a GB CPU. Flags are represented as booleans too so that it's easier to use them as such.
@@ -69,3 +70,12 @@ func (r *Registers) DE() uint16 {
 func (r *Registers) HL() uint16 {
 	return uint16(r.H)<<8 + uint16(r.L)
 }
+
+// SaveState writes all the registers, flags included.
+func (r *Registers) SaveState(e *state.Encoder) {
+	e.Write(r)
+}
+
+func (r *Registers) LoadState(d *state.Decoder) {
+	d.Read(r)
+}
@@ -0,0 +1,169 @@
+package rewind
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// Every this many snapshots, a whole state is kept as keyframe. The ones in between only keep what changed since it.
+const keyframeInterval = 30
+
+// Snapshotter is whatever can save and load its state, like the game.
+type Snapshotter interface {
+	SaveState(w io.Writer) error
+	LoadState(r io.Reader) error
+}
+
+// Buffer keeps snapshots of the last moments of the game to step back through them.
+// A snapshot is taken every Interval frames. Keyframes are compressed whole states, and the snapshots after them
+// are compressed deltas against them: the XOR of both states, which is mostly zeros since little changes between frames.
+// When the snapshots take more than Budget bytes, the oldest keyframe is dropped along with its deltas.
+type Buffer struct {
+	Interval int
+	Budget   int
+
+	snapshots []snapshot // From oldest to newest.
+	size      int        // Bytes taken by all the snapshots.
+	frames    int        // Frames since the last snapshot.
+	// The newest keyframe, uncompressed, and snapshots taken since it. Without it, the next snapshot is a keyframe.
+	keyframe      []byte
+	sinceKeyframe int
+
+	state      bytes.Buffer
+	compressed bytes.Buffer
+	compressor *flate.Writer
+}
+
+type snapshot struct {
+	keyframe bool
+	data     []byte
+}
+
+// New returns a buffer that takes a snapshot every interval frames, using about budget bytes at most.
+func New(interval, budget int) *Buffer {
+	if interval < 1 {
+		interval = 1
+	}
+	compressor, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &Buffer{Interval: interval, Budget: budget, compressor: compressor}
+}
+
+// Record has to be called after every frame. Every Interval frames, it takes a snapshot.
+func (b *Buffer) Record(s Snapshotter) error {
+	b.frames++
+	if b.frames < b.Interval {
+		return nil
+	}
+	b.frames = 0
+
+	b.state.Reset()
+	if err := s.SaveState(&b.state); err != nil {
+		return err
+	}
+	state := b.state.Bytes()
+	var snap snapshot
+	if b.keyframe == nil || b.sinceKeyframe >= keyframeInterval || len(state) != len(b.keyframe) {
+		b.keyframe = append(b.keyframe[:0], state...)
+		b.sinceKeyframe = 0
+		snap.keyframe = true
+	} else {
+		xor(state, b.keyframe)
+	}
+	b.sinceKeyframe++
+	snap.data = b.compress(state)
+	b.snapshots = append(b.snapshots, snap)
+	b.size += len(snap.data)
+	b.trim()
+	return nil
+}
+
+// StepBack loads the newest snapshot and forgets it, so that calling it again goes further back.
+// It returns false when there are no snapshots left.
+func (b *Buffer) StepBack(s Snapshotter) (bool, error) {
+	if len(b.snapshots) == 0 {
+		return false, nil
+	}
+	last := len(b.snapshots) - 1
+	state, err := b.decode(last)
+	if err != nil {
+		return false, err
+	}
+	b.size -= len(b.snapshots[last].data)
+	b.snapshots = b.snapshots[:last]
+	// The game carries on from here, so start again with a new keyframe.
+	b.keyframe = nil
+	b.frames = 0
+	return true, s.LoadState(bytes.NewReader(state))
+}
+
+// Len returns how many snapshots there are to step back through.
+func (b *Buffer) Len() int {
+	return len(b.snapshots)
+}
+
+// Size returns the bytes taken by the snapshots.
+func (b *Buffer) Size() int {
+	return b.size
+}
+
+// Drop the oldest keyframes and their deltas until the snapshots fit in the budget.
+// The newest keyframe is always kept, since the next snapshots need it.
+func (b *Buffer) trim() {
+	for b.size > b.Budget {
+		// Find where the second keyframe starts. Everything before it goes.
+		next := 1
+		for next < len(b.snapshots) && !b.snapshots[next].keyframe {
+			next++
+		}
+		if next == len(b.snapshots) {
+			return
+		}
+		for _, snap := range b.snapshots[:next] {
+			b.size -= len(snap.data)
+		}
+		// Copy the rest to the start, so that the dropped ones can be freed.
+		b.snapshots = append(b.snapshots[:0], b.snapshots[next:]...)
+	}
+}
+
+// Decompress the snapshot at the given index into a whole state.
+func (b *Buffer) decode(i int) ([]byte, error) {
+	state, err := decompress(b.snapshots[i].data)
+	if err != nil || b.snapshots[i].keyframe {
+		return state, err
+	}
+	// Deltas are against the keyframe before them.
+	k := i - 1
+	for !b.snapshots[k].keyframe {
+		k--
+	}
+	keyframe, err := decompress(b.snapshots[k].data)
+	if err != nil {
+		return nil, err
+	}
+	xor(state, keyframe)
+	return state, nil
+}
+
+func (b *Buffer) compress(data []byte) []byte {
+	b.compressed.Reset()
+	b.compressor.Reset(&b.compressed)
+	// Writing to a bytes.Buffer can't fail.
+	_, _ = b.compressor.Write(data)
+	_ = b.compressor.Close()
+	return append([]byte(nil), b.compressed.Bytes()...)
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// XOR src into dst. Both have the same length.
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,181 @@
+package rewind
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// A machine whose state is some random bytes, of which only a few change every frame.
+type machine struct {
+	state []byte
+	frame int
+}
+
+func newMachine(size int) *machine {
+	m := &machine{state: make([]byte, size)}
+	rand.New(rand.NewSource(1)).Read(m.state)
+	return m
+}
+
+func (m *machine) run() {
+	m.frame++
+	m.state[m.frame%len(m.state)]++
+	m.state[0] = byte(m.frame)
+}
+
+func (m *machine) SaveState(w io.Writer) error {
+	_, err := w.Write(m.state)
+	return err
+}
+
+func (m *machine) LoadState(r io.Reader) error {
+	state, err := io.ReadAll(r)
+	m.state = state
+	return err
+}
+
+func TestStepBack(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  int
+		frames    int
+		snapshots int
+	}{
+		{"every frame", 1, 10, 10},
+		{"every 4 frames", 4, 42, 10},
+		{"several keyframes", 1, 2*keyframeInterval + 5, 2*keyframeInterval + 5},
+		{"interval of 0", 0, 3, 3},
+	}
+	for _, test := range tests {
+		b := New(test.interval, 1<<30)
+		m := newMachine(4096)
+		var states [][]byte
+		for i := 0; i < test.frames; i++ {
+			m.run()
+			if err := b.Record(m); err != nil {
+				t.Fatal(err)
+			}
+			if b.Len() > len(states) {
+				states = append(states, append([]byte{}, m.state...))
+			}
+		}
+		if b.Len() != test.snapshots {
+			t.Errorf("%s: %d snapshots, want %d", test.name, b.Len(), test.snapshots)
+		}
+		// Every snapshot comes back exactly as it was, from the newest to the oldest.
+		for i := len(states) - 1; i >= 0; i-- {
+			ok, err := b.StepBack(m)
+			if !ok || err != nil {
+				t.Fatalf("%s: stepping back to snapshot %d: %v, %v", test.name, i, ok, err)
+			}
+			if !bytes.Equal(m.state, states[i]) {
+				t.Fatalf("%s: snapshot %d isn't the state it was taken from", test.name, i)
+			}
+		}
+		if ok, _ := b.StepBack(m); ok || b.Len() != 0 || b.Size() != 0 {
+			t.Errorf("%s: stepped back further than the oldest snapshot", test.name)
+		}
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	b := New(1, 1<<30)
+	m := newMachine(64 << 10)
+	for i := 0; i < keyframeInterval+1; i++ {
+		m.run()
+		if err := b.Record(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, snap := range b.snapshots {
+		if keyframe := i%keyframeInterval == 0; snap.keyframe != keyframe {
+			t.Errorf("snapshot %d: keyframe %v, want %v", i, snap.keyframe, keyframe)
+		}
+	}
+	// Random bytes can't be compressed, but what changes between them can.
+	keyframe, delta := len(b.snapshots[0].data), len(b.snapshots[1].data)
+	if keyframe < len(m.state) || delta > len(m.state)/100 {
+		t.Errorf("keyframe of %d bytes and delta of %d bytes, for a state of %d", keyframe, delta, len(m.state))
+	}
+
+	// A state of another size can't be a delta.
+	m.state = append(m.state, 0)
+	if err := b.Record(m); err != nil {
+		t.Fatal(err)
+	}
+	if !b.snapshots[len(b.snapshots)-1].keyframe {
+		t.Error("a state of another size was kept as a delta")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	m := newMachine(4096)
+	// Enough for a bit more than 2 keyframes and their deltas.
+	b := New(1, 0)
+	for i := 0; i < keyframeInterval; i++ {
+		m.run()
+		b.Record(m)
+	}
+	b.Budget = b.Size()*2 + b.Size()/2
+
+	var states [][]byte
+	for i := 0; i < 10*keyframeInterval; i++ {
+		m.run()
+		if err := b.Record(m); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, append([]byte{}, m.state...))
+		if b.Size() > b.Budget {
+			t.Fatalf("frame %d: %d bytes taken, over the budget of %d", i, b.Size(), b.Budget)
+		}
+	}
+	if !b.snapshots[0].keyframe {
+		t.Error("the oldest snapshot isn't a keyframe")
+	}
+	if b.Len() < 2*keyframeInterval || b.Len() > 3*keyframeInterval {
+		t.Errorf("%d snapshots kept", b.Len())
+	}
+	for i := len(states) - 1; b.Len() > 0; i-- {
+		if _, err := b.StepBack(m); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m.state, states[i]) {
+			t.Fatalf("snapshot of frame %d isn't the state it was taken from", i)
+		}
+	}
+
+	// The newest keyframe is kept even if it doesn't fit.
+	b = New(1, 1)
+	m.run()
+	b.Record(m)
+	if b.Len() != 1 {
+		t.Errorf("%d snapshots kept with a budget of 1 byte", b.Len())
+	}
+}
+
+func TestRecordAfterStepBack(t *testing.T) {
+	b := New(1, 1<<30)
+	m := newMachine(1024)
+	for i := 0; i < 5; i++ {
+		m.run()
+		b.Record(m)
+	}
+	b.StepBack(m)
+	b.StepBack(m)
+	// The game carries on from the third snapshot, with a new keyframe.
+	m.run()
+	b.Record(m)
+	want := append([]byte{}, m.state...)
+	if !b.snapshots[b.Len()-1].keyframe {
+		t.Error("the first snapshot after stepping back isn't a keyframe")
+	}
+	m.run()
+	b.Record(m)
+	b.StepBack(m)
+	b.StepBack(m)
+	if !bytes.Equal(m.state, want) {
+		t.Error("stepping back after recording again doesn't give the state recorded")
+	}
+}
@@ -0,0 +1,112 @@
+package serial
+
+import (
+	"go-boy/internal/memory"
+	"go-boy/internal/state"
+	"io"
+)
+
+// The serial port shifts bits at 8192Hz when it uses its own clock, so one bit every 512 cycles.
+const cyclesPerBit = 4194304 / 8192
+
+// Device is whatever is plugged at the other end of the link cable.
+type Device interface {
+	// Exchange is called when the Game Boy starts a transfer with its own clock.
+	// It gets the byte the Game Boy sends and returns the one it receives in exchange.
+	Exchange(out byte) byte
+}
+
+// Clocked is implemented by devices that can clock transfers themselves, like another Game Boy.
+type Clocked interface {
+	Device
+	// Clock is called on every step to find out if the other end has clocked a transfer.
+	// If it has, it gets the byte in SB, which is sent back only if the Game Boy is ready for a transfer
+	// with an external clock, and returns the byte received.
+	Clock(sb byte, ready bool) (in byte, clocked bool)
+}
+
+// Serial emulates the serial port: SB (0xFF01) holds the byte being transferred, and SC (0xFF02)
+// starts a transfer (bit 7) and chooses who clocks it (bit 0, 1 being the Game Boy itself).
+// During a transfer, the 8 bits of SB are shifted out one at a time, MSB first, while the ones of
+// the other end are shifted in. When it's over, bit 7 of SC is reset and the serial interrupt is requested.
+type Serial struct {
+	// Nothing connected means the line stays high, so 0xFF is received.
+	Device       Device
+	transferring bool
+	cycles       int  // Cycles since the last bit was shifted.
+	bits         int  // Bits shifted in the current transfer.
+	in           byte // Byte being received.
+}
+
+func InitSerial() *Serial {
+	return new(Serial)
+}
+
+// Step advances the current transfer, if there's one, by some cycles.
+func (s *Serial) Step(cycles int, m *memory.Memory) {
+	sc := m.Read(0xFF02)
+	// Transfers with an external clock happen whenever the other end wants.
+	// While the Game Boy clocks its own transfer, whatever the other end sends is part of that one instead.
+	if device, ok := s.Device.(Clocked); ok && sc&0x81 != 0x81 {
+		ready := sc&0x81 == 0x80
+		if in, clocked := device.Clock(m.Read(0xFF01), ready); clocked && ready {
+			m.Store(0xFF01, in)
+			s.complete(m, sc)
+			return
+		}
+	}
+	// Only transfers with the internal clock advance on their own.
+	if sc&0x81 != 0x81 {
+		s.transferring = false
+		return
+	}
+	if !s.transferring {
+		// A new transfer just started. Find out what the other end sends back.
+		s.transferring = true
+		s.cycles = 0
+		s.bits = 0
+		s.in = 0xFF
+		if s.Device != nil {
+			s.in = s.Device.Exchange(m.Read(0xFF01))
+		}
+	}
+	s.cycles += cycles
+	for s.cycles >= cyclesPerBit && s.transferring {
+		s.cycles -= cyclesPerBit
+		// Shift out the MSB of SB and shift in the next bit of the received byte.
+		bit := (s.in >> (7 - s.bits)) & 0x01
+		m.Store(0xFF01, m.Read(0xFF01)<<1|bit)
+		s.bits++
+		if s.bits == 8 {
+			s.transferring = false
+			s.complete(m, sc)
+		}
+	}
+}
+
+// Transfer complete. Reset the start bit and request the serial interrupt.
+func (s *Serial) complete(m *memory.Memory, sc byte) {
+	m.Store(0xFF02, sc&0x7F)
+	m.Store(0xFF0F, m.Read(0xFF0F)|0x08)
+}
+
+// TextSink turns the serial port into a text output: every byte sent is written as is to W.
+// Test ROMs like Blargg's report their results this way, so they can be read without a screen.
+type TextSink struct {
+	W io.Writer
+}
+
+// Exchange writes the byte sent. Nothing is connected on the other end, so it receives 0xFF.
+func (t *TextSink) Exchange(out byte) byte {
+	_, _ = t.W.Write([]byte{out})
+	return 0xFF
+}
+
+// SaveState writes the transfer in progress, if any. Whatever is plugged to the port isn't saved.
+func (s *Serial) SaveState(e *state.Encoder) {
+	e.Write(s.transferring, s.cycles, s.bits, s.in)
+}
+
+func (s *Serial) LoadState(d *state.Decoder) {
+	d.Read(&s.transferring, &s.cycles, &s.bits, &s.in)
+}
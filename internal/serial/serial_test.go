@@ -0,0 +1,144 @@
+package serial
+
+import (
+	"go-boy/internal/memory"
+	"strings"
+	"testing"
+)
+
+// A device that answers every transfer with the same byte, and keeps the ones sent.
+type echo struct {
+	reply byte
+	sent  []byte
+}
+
+func (e *echo) Exchange(out byte) byte {
+	e.sent = append(e.sent, out)
+	return e.reply
+}
+
+// Another Game Boy that clocks a transfer as soon as it's asked.
+type clocking struct {
+	echo
+	pending bool
+	out     byte
+	got     []byte // SB of this end when it replied.
+}
+
+func (c *clocking) Clock(sb byte, ready bool) (byte, bool) {
+	if !c.pending {
+		return 0xFF, false
+	}
+	c.pending = false
+	if ready {
+		c.got = append(c.got, sb)
+	}
+	return c.out, true
+}
+
+func newMemory() *memory.Memory {
+	return &memory.Memory{IOPorts: make([]byte, 0x4C)}
+}
+
+func TestInternalClock(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   Device
+		out, in  byte
+		received byte
+	}{
+		{"nothing plugged", nil, 0x5A, 0xFF, 0xFF},
+		{"device", &echo{reply: 0x3C}, 0xA5, 0x3C, 0x3C},
+		{"zero", &echo{reply: 0x00}, 0xFF, 0x00, 0x00},
+	}
+	for _, test := range tests {
+		s := InitSerial()
+		s.Device = test.device
+		m := newMemory()
+		m.Store(0xFF01, test.out)
+		m.Store(0xFF02, 0x81)
+		// One bit every 512 cycles, MSB first.
+		for bit := 1; bit <= 8; bit++ {
+			s.Step(cyclesPerBit-1, m)
+			s.Step(1, m)
+			want := byte(uint16(test.out)<<bit | uint16(test.received)>>(8-bit))
+			if sb := m.Read(0xFF01); sb != want {
+				t.Errorf("%s: SB is %02X after %d bits, want %02X", test.name, sb, bit, want)
+			}
+		}
+		if sc := m.Read(0xFF02); sc != 0x01 {
+			t.Errorf("%s: SC is %02X after the transfer, want 01", test.name, sc)
+		}
+		if m.Read(0xFF0F)&0x08 == 0 {
+			t.Errorf("%s: no serial interrupt after the transfer", test.name)
+		}
+		if e, ok := test.device.(*echo); ok && (len(e.sent) != 1 || e.sent[0] != test.out) {
+			t.Errorf("%s: sent %X, want %02X once", test.name, e.sent, test.out)
+		}
+	}
+}
+
+func TestNoTransfer(t *testing.T) {
+	s := InitSerial()
+	e := &echo{reply: 0x12}
+	s.Device = e
+	m := newMemory()
+	m.Store(0xFF01, 0x34)
+	// Without the start bit, or with an external clock and nothing clocking it, nothing happens.
+	for _, sc := range []byte{0x00, 0x01, 0x80} {
+		m.Store(0xFF02, sc)
+		s.Step(10*cyclesPerBit, m)
+		if m.Read(0xFF01) != 0x34 || m.Read(0xFF02) != sc || m.Read(0xFF0F) != 0 || len(e.sent) != 0 {
+			t.Errorf("SC %02X: something was transferred", sc)
+		}
+	}
+}
+
+func TestExternalClock(t *testing.T) {
+	tests := []struct {
+		name     string
+		sc       byte
+		ready    bool
+		sb       byte
+		complete bool
+	}{
+		{"ready", 0x80, true, 0x77, true},
+		{"not ready", 0x00, false, 0x42, false},
+	}
+	for _, test := range tests {
+		s := InitSerial()
+		c := &clocking{pending: true, out: 0x77}
+		s.Device = c
+		m := newMemory()
+		m.Store(0xFF01, 0x42)
+		m.Store(0xFF02, test.sc)
+		s.Step(4, m)
+		if sb := m.Read(0xFF01); sb != test.sb {
+			t.Errorf("%s: SB is %02X, want %02X", test.name, sb, test.sb)
+		}
+		if complete := m.Read(0xFF0F)&0x08 != 0; complete != test.complete {
+			t.Errorf("%s: interrupt requested: %v, want %v", test.name, complete, test.complete)
+		}
+		if test.ready && (len(c.got) != 1 || c.got[0] != 0x42) {
+			t.Errorf("%s: the other end got %X, want 42", test.name, c.got)
+		}
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	var b strings.Builder
+	s := InitSerial()
+	s.Device = &TextSink{W: &b}
+	m := newMemory()
+	for _, c := range []byte("Passed\n") {
+		m.Store(0xFF01, c)
+		m.Store(0xFF02, 0x81)
+		s.Step(8*cyclesPerBit, m)
+		if sb := m.Read(0xFF01); sb != 0xFF {
+			t.Errorf("received %02X from the sink, want FF", sb)
+		}
+	}
+	if b.String() != "Passed\n" {
+		t.Errorf("got %q from the sink", b.String())
+	}
+}
@@ -0,0 +1,111 @@
+package sound
+
+import (
+	"go-boy/internal/apu"
+	"sync"
+	"time"
+)
+
+// Bytes repeated when the buffer runs out, 128 stereo samples.
+const underrunPadding = 128 * 4
+
+// How much the sample rate can be bent to keep the buffer half full. 0.5% can't be heard.
+const maxRateDelta = 0.005
+
+// Buffer is a ring buffer of stereo 16 bit samples between the APU, which writes them as the game runs,
+// and whatever plays them, which reads them as little endian PCM whenever it needs more.
+// Both ends can be in different goroutines.
+type Buffer struct {
+	mu      sync.Mutex
+	samples [][2]int16
+	start   int // First sample to be read.
+	length  int // Samples waiting to be read.
+	last    [2]int16
+	written chan struct{}
+}
+
+// NewBuffer returns a buffer that can hold up to the given number of stereo samples.
+func NewBuffer(capacity int) *Buffer {
+	return &Buffer{
+		samples: make([][2]int16, capacity),
+		written: make(chan struct{}, 1),
+	}
+}
+
+// WriteSample adds a sample at the end of the buffer. If it's full, the sample is dropped.
+func (b *Buffer) WriteSample(s apu.Sample) {
+	b.mu.Lock()
+	if b.length < len(b.samples) {
+		b.samples[(b.start+b.length)%len(b.samples)] = [2]int16{toInt16(s.Left), toInt16(s.Right)}
+		b.length++
+	}
+	b.mu.Unlock()
+	// Wake up the reader if it's waiting.
+	select {
+	case b.written <- struct{}{}:
+	default:
+	}
+}
+
+func toInt16(v float32) int16 {
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	return int16(v * 0x7FFF)
+}
+
+// Read fills p with samples as 16 bit little endian PCM, left first.
+// If the buffer is empty, it waits a bit for the game to make some more. If it's still empty after that,
+// the last sample is repeated for a moment instead, which doesn't click like silence would.
+func (b *Buffer) Read(p []byte) (int, error) {
+	if len(p) < 4 {
+		return 0, nil
+	}
+	b.mu.Lock()
+	empty := b.length == 0
+	b.mu.Unlock()
+	if empty {
+		select {
+		case <-b.written:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for ; n+4 <= len(p) && b.length > 0; n += 4 {
+		b.last = b.samples[b.start]
+		b.start = (b.start + 1) % len(b.samples)
+		b.length--
+		putSample(p[n:], b.last)
+	}
+	// Never return nothing, or the player would keep asking without waiting.
+	for ; n == 0 || n+4 <= len(p) && n < underrunPadding; n += 4 {
+		putSample(p[n:], b.last)
+	}
+	return n, nil
+}
+
+func putSample(p []byte, s [2]int16) {
+	p[0] = byte(s[0])
+	p[1] = byte(uint16(s[0]) >> 8)
+	p[2] = byte(s[1])
+	p[3] = byte(uint16(s[1]) >> 8)
+}
+
+// Fill returns how full the buffer is, from 0 to 1.
+func (b *Buffer) Fill() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return float64(b.length) / float64(len(b.samples))
+}
+
+// Rate returns the sample rate the APU should use so that the buffer stays half full, given the rate it's played at.
+// When the buffer fills up, the APU makes slightly fewer samples, and when it empties, slightly more.
+// That way the game can run at whatever speed the screen refreshes without the sound cracking.
+func (b *Buffer) Rate(playbackRate int) int {
+	return int(float64(playbackRate) * (1 + maxRateDelta*(1-2*b.Fill())))
+}
@@ -0,0 +1,85 @@
+package sound
+
+import (
+	"go-boy/internal/apu"
+	"testing"
+)
+
+func TestToInt16(t *testing.T) {
+	tests := []struct {
+		v    float32
+		want int16
+	}{
+		{0, 0},
+		{1, 0x7FFF},
+		{-1, -0x7FFF},
+		{0.5, 0x3FFF},
+		{2, 0x7FFF},
+		{-3, -0x7FFF},
+	}
+	for _, test := range tests {
+		if got := toInt16(test.v); got != test.want {
+			t.Errorf("toInt16(%v): got %d, want %d", test.v, got, test.want)
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(4)
+	for _, v := range []float32{0.5, -0.5, 1, -1, 0.25} {
+		b.WriteSample(apu.Sample{Left: v, Right: -v})
+	}
+	// The last sample didn't fit.
+	if b.Fill() != 1 {
+		t.Errorf("buffer %v full, want 1", b.Fill())
+	}
+	p := make([]byte, 12)
+	if n, _ := b.Read(p); n != 12 {
+		t.Fatalf("read %d bytes, want 12", n)
+	}
+	want := []byte{0xFF, 0x3F, 0x01, 0xC0, 0x01, 0xC0, 0xFF, 0x3F, 0xFF, 0x7F, 0x01, 0x80}
+	for i := range want {
+		if p[i] != want[i] {
+			t.Fatalf("read % X, want % X", p, want)
+		}
+	}
+	if b.Fill() != 0.25 {
+		t.Errorf("buffer %v full, want 0.25", b.Fill())
+	}
+	// Once it runs out, the last sample is repeated.
+	p = make([]byte, 16)
+	if n, _ := b.Read(p); n != 16 {
+		t.Fatalf("read %d bytes, want 16", n)
+	}
+	for i := 4; i < 16; i += 4 {
+		if p[i] != 0x01 || p[i+1] != 0x80 || p[i+2] != 0xFF || p[i+3] != 0x7F {
+			t.Errorf("got % X after running out, want the last sample repeated", p)
+			break
+		}
+	}
+	if n, _ := b.Read(make([]byte, 3)); n != 0 {
+		t.Errorf("read %d bytes into less than a sample", n)
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		samples int
+		want    int
+	}{
+		{0, 48240},
+		{50, 48000},
+		{100, 47760},
+		{25, 48120},
+	}
+	for _, test := range tests {
+		b := NewBuffer(100)
+		for i := 0; i < test.samples; i++ {
+			b.WriteSample(apu.Sample{})
+		}
+		// Rounding can take one off.
+		if rate := b.Rate(48000); rate != test.want && rate != test.want-1 {
+			t.Errorf("%d%% full: rate %d, want %d", test.samples, rate, test.want)
+		}
+	}
+}
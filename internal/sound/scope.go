@@ -0,0 +1,24 @@
+package sound
+
+// TriggerPoint finds where a wave first goes up through the middle between its lowest and highest values,
+// early enough to have the given number of samples after it, so that an oscilloscope drawing from there stands still.
+// If it never does, the newest samples are drawn.
+func TriggerPoint(samples []float32, shown int) int {
+	low, high := samples[0], samples[0]
+	for _, v := range samples {
+		if v < low {
+			low = v
+		}
+		if v > high {
+			high = v
+		}
+	}
+	middle := (low + high) / 2
+	last := len(samples) - shown
+	for i := 1; i <= last && low != high; i++ {
+		if samples[i-1] < middle && samples[i] >= middle {
+			return i
+		}
+	}
+	return last
+}
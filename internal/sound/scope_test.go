@@ -0,0 +1,38 @@
+package sound
+
+import "testing"
+
+func TestTriggerPoint(t *testing.T) {
+	// A square wave going up at 3, 11 and 19.
+	square := make([]float32, 24)
+	for i := range square {
+		if i%8 >= 3 && i%8 < 7 {
+			square[i] = 1
+		}
+	}
+	// A wave that only goes up late, and one going down all the way.
+	late := make([]float32, 24)
+	late[20] = 1
+	down := make([]float32, 24)
+	for i := range down {
+		down[i] = float32(-i)
+	}
+	tests := []struct {
+		name    string
+		samples []float32
+		shown   int
+		want    int
+	}{
+		{"square", square, 12, 3},
+		{"offset", square[4:], 12, 7},
+		{"too late", late, 12, 12},
+		{"down", down, 12, 12},
+		{"flat", make([]float32, 24), 12, 12},
+		{"all shown", square, 24, 0},
+	}
+	for _, test := range tests {
+		if got := TriggerPoint(test.samples, test.shown); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
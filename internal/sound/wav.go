@@ -0,0 +1,143 @@
+package sound
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"go-boy/internal/apu"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Size of the header of a WAV file with nothing but the format and the data chunks.
+const wavHeaderSize = 44
+
+// WAVRecorder saves the sound of the APU as 16 bit PCM WAV files: the mixed output in stereo and,
+// optionally, the output of each channel in a mono file of its own.
+type WAVRecorder struct {
+	mixed    *wavFile
+	channels [4]*wavFile
+}
+
+// RecordWAV creates a stereo WAV file with the given sample rate. With perChannel, it also creates
+// one file for each channel next to it, as sound-ch1.wav, sound-ch2.wav... for sound.wav.
+func RecordWAV(filename string, sampleRate int, perChannel bool) (*WAVRecorder, error) {
+	r := &WAVRecorder{}
+	var err error
+	if r.mixed, err = createWAV(filename, sampleRate, 2); err != nil {
+		return nil, err
+	}
+	if perChannel {
+		prefix := strings.TrimSuffix(filename, filepath.Ext(filename))
+		for i := range r.channels {
+			if r.channels[i], err = createWAV(fmt.Sprintf("%s-ch%d.wav", prefix, i+1), sampleRate, 1); err != nil {
+				r.Close()
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// WriteSample adds a sample to the end of every file.
+func (r *WAVRecorder) WriteSample(s apu.Sample) {
+	r.mixed.write(toInt16(s.Left), toInt16(s.Right))
+	for i, f := range r.channels {
+		if f != nil {
+			f.write(toInt16(s.Channels[i]))
+		}
+	}
+}
+
+// Close writes the final sizes in the headers and closes the files.
+// It returns the first error found while writing any of them.
+func (r *WAVRecorder) Close() error {
+	var err error
+	for _, f := range append([]*wavFile{r.mixed}, r.channels[:]...) {
+		if f == nil {
+			continue
+		}
+		if closeErr := f.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type wavFile struct {
+	file *os.File
+	w    *bufio.Writer
+	size uint32 // Bytes of samples written.
+	err  error  // First error while writing, returned when it's closed.
+}
+
+// Create a WAV file and write its header. The sizes in it are filled in when it's closed.
+func createWAV(filename string, sampleRate int, channels int) (*wavFile, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &wavFile{file: file, w: bufio.NewWriter(file)}
+	blockAlign := channels * 2
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'}, uint32(0), [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16),
+		uint16(1), // PCM
+		uint16(channels), uint32(sampleRate), uint32(sampleRate * blockAlign), uint16(blockAlign),
+		uint16(16), // Bits per sample
+		[4]byte{'d', 'a', 't', 'a'}, uint32(0),
+	}
+	for _, field := range header {
+		if err = binary.Write(f.w, binary.LittleEndian, field); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *wavFile) write(values ...int16) {
+	if f.err != nil {
+		return
+	}
+	for _, v := range values {
+		if f.err = f.w.WriteByte(byte(v)); f.err != nil {
+			return
+		}
+		if f.err = f.w.WriteByte(byte(uint16(v) >> 8)); f.err != nil {
+			return
+		}
+		f.size += 2
+	}
+}
+
+func (f *wavFile) close() error {
+	err := f.err
+	if err == nil {
+		err = f.w.Flush()
+	}
+	// Fill in the size of the RIFF chunk and of the data.
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], wavHeaderSize-8+f.size)
+	if _, writeErr := f.file.WriteAt(size[:], 4); err == nil {
+		err = writeErr
+	}
+	binary.LittleEndian.PutUint32(size[:], f.size)
+	if _, writeErr := f.file.WriteAt(size[:], wavHeaderSize-4); err == nil {
+		err = writeErr
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Tee sends every sample to several outputs, like the speakers and a WAV file.
+type Tee []apu.Output
+
+func (t Tee) WriteSample(s apu.Sample) {
+	for _, o := range t {
+		o.WriteSample(s)
+	}
+}
@@ -0,0 +1,88 @@
+package sound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go-boy/internal/apu"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fields of a WAV header, in order.
+type wavHeader struct {
+	RIFF          [4]byte
+	RIFFSize      uint32
+	WAVE          [4]byte
+	Fmt           [4]byte
+	FmtSize       uint32
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte
+	DataSize      uint32
+}
+
+func readWAV(t *testing.T, filename string) (wavHeader, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h wavHeader
+	if err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	return h, data[wavHeaderSize:]
+}
+
+func TestRecordWAV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "music.wav")
+	r, err := RecordWAV(filename, 48000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.WriteSample(apu.Sample{Left: 1, Right: -1, Channels: [4]float32{0.5, 0, -0.5, 1}})
+	r.WriteSample(apu.Sample{Left: 0, Right: 0.5, Channels: [4]float32{0, 1, 0, -1}})
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filename string
+		channels uint16
+		samples  []byte
+	}{
+		{"music.wav", 2, []byte{0xFF, 0x7F, 0x01, 0x80, 0x00, 0x00, 0xFF, 0x3F}},
+		{"music-ch1.wav", 1, []byte{0xFF, 0x3F, 0x00, 0x00}},
+		{"music-ch2.wav", 1, []byte{0x00, 0x00, 0xFF, 0x7F}},
+		{"music-ch3.wav", 1, []byte{0x01, 0xC0, 0x00, 0x00}},
+		{"music-ch4.wav", 1, []byte{0xFF, 0x7F, 0x01, 0x80}},
+	}
+	for _, test := range tests {
+		h, samples := readWAV(t, filepath.Join(filepath.Dir(filename), test.filename))
+		want := wavHeader{
+			RIFF: [4]byte{'R', 'I', 'F', 'F'}, RIFFSize: uint32(36 + len(test.samples)), WAVE: [4]byte{'W', 'A', 'V', 'E'},
+			Fmt: [4]byte{'f', 'm', 't', ' '}, FmtSize: 16, Format: 1, Channels: test.channels, SampleRate: 48000,
+			ByteRate: 48000 * 2 * uint32(test.channels), BlockAlign: 2 * test.channels, BitsPerSample: 16,
+			Data: [4]byte{'d', 'a', 't', 'a'}, DataSize: uint32(len(test.samples)),
+		}
+		if h != want {
+			t.Errorf("%s: got header %+v, want %+v", test.filename, h, want)
+		}
+		if !bytes.Equal(samples, test.samples) {
+			t.Errorf("%s: got samples % X, want % X", test.filename, samples, test.samples)
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	a, b := NewBuffer(4), NewBuffer(4)
+	Tee{a, b}.WriteSample(apu.Sample{Left: 1})
+	if a.Fill() != 0.25 || b.Fill() != 0.25 {
+		t.Errorf("got %v and %v full, want both 0.25", a.Fill(), b.Fill())
+	}
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Encoder writes the state of the machine's parts one value after the other, in little endian.
+// Values can be anything with a fixed size, like bytes, bools, arrays, slices of them or structs made of them,
+// plus ints, which are written as 64 bits. The first error is kept, and nothing else is written after it.
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Write writes the given values in order.
+func (e *Encoder) Write(values ...interface{}) {
+	for _, v := range values {
+		if e.err != nil {
+			return
+		}
+		if n, ok := v.(int); ok {
+			v = int64(n)
+		}
+		e.err = binary.Write(e.w, binary.LittleEndian, v)
+	}
+}
+
+// Err returns the first error found while writing.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+// Decoder reads back what an Encoder wrote. Values are read into pointers to them,
+// or straight into slices, which have to be as long as the ones written.
+type Decoder struct {
+	r   io.Reader
+	err error
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Read reads the given values in order.
+func (d *Decoder) Read(values ...interface{}) {
+	for _, v := range values {
+		if d.err != nil {
+			return
+		}
+		if p, ok := v.(*int); ok {
+			var n int64
+			d.err = binary.Read(d.r, binary.LittleEndian, &n)
+			*p = int(n)
+			continue
+		}
+		d.err = binary.Read(d.r, binary.LittleEndian, v)
+	}
+}
+
+// Err returns the first error found while reading.
+func (d *Decoder) Err() error {
+	return d.err
+}
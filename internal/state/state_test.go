@@ -0,0 +1,71 @@
+package state
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	type values struct {
+		B     byte
+		U16   uint16
+		U32   uint32
+		U64   uint64
+		N     int
+		Neg   int
+		Flag  bool
+		Bytes []byte
+		Array [3]uint16
+	}
+	tests := []values{
+		{},
+		{B: 0xAB, U16: 0xBEEF, U32: 0xDEADBEEF, U64: 1 << 40, N: 123456789, Neg: -5, Flag: true,
+			Bytes: []byte{1, 2, 3}, Array: [3]uint16{4, 5, 6}},
+	}
+	for _, in := range tests {
+		var b bytes.Buffer
+		e := NewEncoder(&b)
+		e.Write(in.B, in.U16, in.U32, in.U64, in.N, in.Neg, in.Flag, in.Bytes, in.Array)
+		if e.Err() != nil {
+			t.Fatal(e.Err())
+		}
+		// ints are always written as 64 bits, whatever their size here.
+		if size := 1 + 2 + 4 + 8 + 8 + 8 + 1 + len(in.Bytes) + 6; b.Len() != size {
+			t.Errorf("wrote %d bytes, want %d", b.Len(), size)
+		}
+		out := values{Bytes: make([]byte, len(in.Bytes))}
+		d := NewDecoder(&b)
+		d.Read(&out.B, &out.U16, &out.U32, &out.U64, &out.N, &out.Neg, &out.Flag, out.Bytes, &out.Array)
+		if d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+		if len(in.Bytes) == 0 {
+			out.Bytes = in.Bytes
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("got %+v back, want %+v", out, in)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	// Reading past the end fails, and so does everything after it.
+	d := NewDecoder(bytes.NewReader([]byte{1, 2, 3}))
+	var a uint16
+	var b uint16
+	var c byte
+	d.Read(&a, &b, &c)
+	if d.Err() == nil {
+		t.Error("read past the end without errors")
+	}
+	if a != 0x0201 || c != 0 {
+		t.Errorf("got %04X and %02X, want 0201 and nothing", a, c)
+	}
+	// Types without a fixed size can't be written.
+	e := NewEncoder(&bytes.Buffer{})
+	e.Write("text")
+	if e.Err() == nil {
+		t.Error("wrote a string without errors")
+	}
+}
@@ -0,0 +1,155 @@
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"go-boy/internal/disasm"
+	"go-boy/internal/registers"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Condition is met from the given frame on, while PC is between Low and High (both included).
+type Condition struct {
+	Frame     uint64
+	Low, High uint16
+}
+
+// Always is a condition that is always met.
+var Always = Condition{Low: 0x0000, High: 0xFFFF}
+
+func (c Condition) met(frame uint64, pc uint16) bool {
+	return frame >= c.Frame && pc >= c.Low && pc <= c.High
+}
+
+// ParseCondition parses comma separated parts like "frame=120", "pc=0150" or "pc=4000-7FFF", where the addresses are
+// in hex or names of symbols. Whatever isn't given is always met.
+func ParseCondition(text string, symbols *disasm.Symbols) (Condition, error) {
+	c := Always
+	for _, part := range strings.Split(text, ",") {
+		fields := strings.SplitN(part, "=", 2)
+		if len(fields) != 2 {
+			return c, fmt.Errorf("bad trace condition %q, expected frame=N or pc=address[-address]", part)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "frame":
+			frame, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return c, fmt.Errorf("bad frame in trace condition %q", part)
+			}
+			c.Frame = frame
+		case "pc":
+			addresses := strings.SplitN(fields[1], "-", 2)
+			low, err := parseAddress(addresses[0], symbols)
+			if err != nil {
+				return c, err
+			}
+			high := low
+			if len(addresses) == 2 {
+				if high, err = parseAddress(addresses[1], symbols); err != nil {
+					return c, err
+				}
+			}
+			if high < low {
+				return c, fmt.Errorf("bad range in trace condition %q", part)
+			}
+			c.Low, c.High = low, high
+		default:
+			return c, fmt.Errorf("bad trace condition %q, expected frame=N or pc=address[-address]", part)
+		}
+	}
+	return c, nil
+}
+
+func parseAddress(text string, symbols *disasm.Symbols) (uint16, error) {
+	if _, address, ok := symbols.Find(text); ok {
+		return address, nil
+	}
+	address, err := strconv.ParseUint(strings.TrimPrefix(text, "$"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q, it has to be in hex or a symbol", text)
+	}
+	return uint16(address), nil
+}
+
+// Writer writes a line per instruction executed with the registers and the bytes at PC before it runs, as in:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// It's the format used by many other emulators and by tools like Gameboy Doctor, so traces can be diffed with theirs.
+// It starts tracing once the Start condition is met, and after that stops for good once the Stop one is.
+type Writer struct {
+	Start Condition
+	// Without a Stop condition, it traces until it's closed.
+	Stop    *Condition
+	Written uint64 // Lines written so far.
+
+	w       *bufio.Writer
+	closers []io.Closer
+	started bool
+	stopped bool
+}
+
+// New returns a writer that writes the trace into w, always tracing until it's closed.
+func New(w io.Writer) *Writer {
+	return &Writer{Start: Always, w: bufio.NewWriterSize(w, 1<<16)}
+}
+
+// Create returns a writer that writes the trace into a new file, compressed with gzip if its name ends with .gz.
+func Create(filename string) (*Writer, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filename, ".gz") {
+		t := New(file)
+		t.closers = []io.Closer{file}
+		return t, nil
+	}
+	// Traces are huge, so the fastest compression is enough. It still makes them many times smaller.
+	gz, err := gzip.NewWriterLevel(file, gzip.BestSpeed)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	t := New(gz)
+	// The gzip writer has to be closed before the file, to write its footer.
+	t.closers = []io.Closer{gz, file}
+	return t, nil
+}
+
+// Instruction traces the instruction about to be executed, if it's time to, reading the bytes at PC with read.
+func (t *Writer) Instruction(r *registers.Registers, frame uint64, read func(address uint16) byte) {
+	if t.stopped {
+		return
+	}
+	if !t.started {
+		if !t.Start.met(frame, r.PC) {
+			return
+		}
+		t.started = true
+	}
+	if t.Stop != nil && t.Stop.met(frame, r.PC) {
+		t.stopped = true
+		return
+	}
+	// Errors are kept by the bufio writer, and returned by Close.
+	fmt.Fprintf(t.w, "A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC,
+		read(r.PC), read(r.PC+1), read(r.PC+2), read(r.PC+3))
+	t.Written++
+}
+
+// Close flushes whatever hasn't been written yet and closes the file, if it was created by Create.
+func (t *Writer) Close() error {
+	err := t.w.Flush()
+	for _, c := range t.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
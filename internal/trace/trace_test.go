@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"compress/gzip"
+	"fmt"
+	"go-boy/internal/disasm"
+	"go-boy/internal/registers"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	symbols := disasm.NewSymbols()
+	symbols.Add(0, 0x0150, "Main")
+	symbols.Add(0, 0x0200, "Main.end")
+	tests := []struct {
+		text      string
+		condition Condition
+		err       bool
+	}{
+		{"frame=120", Condition{Frame: 120, Low: 0x0000, High: 0xFFFF}, false},
+		{"pc=0150", Condition{Low: 0x0150, High: 0x0150}, false},
+		{"pc=$4000-7fff", Condition{Low: 0x4000, High: 0x7FFF}, false},
+		{"frame=120,pc=0150-01FF", Condition{Frame: 120, Low: 0x0150, High: 0x01FF}, false},
+		{"PC=Main", Condition{Low: 0x0150, High: 0x0150}, false},
+		{"pc=Main-Main.end", Condition{Low: 0x0150, High: 0x0200}, false},
+		{"frame", Condition{}, true},
+		{"frame=-1", Condition{}, true},
+		{"pc=Missing", Condition{}, true},
+		{"pc=10000", Condition{}, true},
+		{"pc=0200-0100", Condition{}, true},
+		{"line=3", Condition{}, true},
+		{"frame=1,", Condition{}, true},
+	}
+	for _, test := range tests {
+		c, err := ParseCondition(test.text, symbols)
+		if (err != nil) != test.err {
+			t.Errorf("%q: got error %v", test.text, err)
+		} else if err == nil && c != test.condition {
+			t.Errorf("%q: got %+v, want %+v", test.text, c, test.condition)
+		}
+	}
+}
+
+// Run PC from 0100 to 0104 in frames 0 to 3, and return the frame and PC of the instructions traced.
+func run(w *Writer) []string {
+	r := registers.GetInitializedRegisters()
+	read := func(address uint16) byte { return 0 }
+	var traced []string
+	for frame := uint64(0); frame <= 3; frame++ {
+		for pc := uint16(0x100); pc <= 0x104; pc++ {
+			r.PC = pc
+			written := w.Written
+			w.Instruction(r, frame, read)
+			if w.Written > written {
+				traced = append(traced, fmt.Sprintf("%d:%04X", frame, pc))
+			}
+		}
+	}
+	return traced
+}
+
+func TestStartAndStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, stop string
+		traced      string
+	}{
+		{"always", "", "", "0:0100 0:0101 0:0102 0:0103 0:0104 1:0100 1:0101 1:0102 1:0103 1:0104 " +
+			"2:0100 2:0101 2:0102 2:0103 2:0104 3:0100 3:0101 3:0102 3:0103 3:0104"},
+		{"start frame", "frame=3", "", "3:0100 3:0101 3:0102 3:0103 3:0104"},
+		// Once started, it goes on outside the range.
+		{"start pc", "frame=2,pc=0103", "", "2:0103 2:0104 3:0100 3:0101 3:0102 3:0103 3:0104"},
+		{"stop frame", "", "frame=1", "0:0100 0:0101 0:0102 0:0103 0:0104"},
+		// Once stopped, it doesn't start again.
+		{"stop pc", "pc=0101", "pc=0102-0103", "0:0101"},
+		{"both", "frame=1,pc=0104", "frame=2,pc=0101", "1:0104 2:0100"},
+		// Meeting the Stop condition before starting doesn't keep it from starting.
+		{"stop before start", "frame=2", "pc=0103", "2:0100 2:0101 2:0102"},
+	}
+	for _, test := range tests {
+		w := New(io.Discard)
+		var err error
+		if test.start != "" {
+			if w.Start, err = ParseCondition(test.start, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.stop != "" {
+			stop, err := ParseCondition(test.stop, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Stop = &stop
+		}
+		if traced := strings.Join(run(w), " "); traced != test.traced {
+			t.Errorf("%s: traced %s, want %s", test.name, traced, test.traced)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	for _, name := range []string{"trace.log", "trace.log.gz"} {
+		filename := filepath.Join(t.TempDir(), name)
+		w, err := Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := registers.GetInitializedRegisters()
+		r.PC = 0x100
+		read := func(address uint16) byte { return []byte{0x00, 0xC3, 0x13, 0x02}[address-0x100] }
+		w.Instruction(r, 0, read)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var in io.Reader = file
+		if strings.HasSuffix(name, ".gz") {
+			if in, err = gzip.NewReader(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := io.ReadAll(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:0100 PCMEM:00,C3,13,02\n",
+			r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP)
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", name, data, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package vram
+
+import (
+	"fmt"
+	"image"
+)
+
+// Shades of the 4 color numbers without a palette, from 0, the lightest, to 3.
+var NoPalette = [4]byte{0, 1, 2, 3}
+
+// Shades returns the shade a palette register, like BGP or OBP0, gives to each of the 4 color numbers.
+func Shades(palette byte) [4]byte {
+	return [4]byte{palette & 0x03, palette >> 2 & 0x03, palette >> 4 & 0x03, palette >> 6}
+}
+
+// Tile decodes the 8x8 tile at the given offset of VRAM into the shades of its pixels, row by row.
+// Each row of a tile is 2 bytes: the first one has the low bits of the color numbers of its 8 pixels, and the second
+// one the high bits.
+func Tile(vram []byte, offset int, shades [4]byte) [8][8]byte {
+	var tile [8][8]byte
+	for y := range tile {
+		low, high := vram[offset+2*y], vram[offset+2*y+1]
+		for x := range tile[y] {
+			bit := 7 - x
+			tile[y][x] = shades[high>>bit&1<<1|low>>bit&1]
+		}
+	}
+	return tile
+}
+
+// MapTileOffset returns the offset in VRAM of the tile with the given number in a tile map.
+// With LCDC bit 4 set the tiles are numbered from 8000, otherwise they're signed, numbered from 9000.
+func MapTileOffset(number, lcdc byte) int {
+	if lcdc&0x10 == 0 {
+		return 0x1000 + 16*int(int8(number))
+	}
+	return 16 * int(number)
+}
+
+// Viewport returns the points of the outline of the screen over the 256x256 background, scrolled by SCX and SCY.
+// It wraps around the edges like the background does.
+func Viewport(scx, scy int) []image.Point {
+	points := make([]image.Point, 0, 2*160+2*144)
+	for x := 0; x < 160; x++ {
+		points = append(points, image.Pt((scx+x)%256, scy), image.Pt((scx+x)%256, (scy+143)%256))
+	}
+	for y := 0; y < 144; y++ {
+		points = append(points, image.Pt(scx, (scy+y)%256), image.Pt((scx+159)%256, (scy+y)%256))
+	}
+	return points
+}
+
+// Sprite is one of the 40 entries of OAM.
+type Sprite struct {
+	Y, X  byte
+	Tile  byte
+	Flags byte
+}
+
+// Sprites reads the 40 sprites in OAM. 8x16 sprites are two tiles, and ignore the lowest bit of the tile number.
+func Sprites(oam []byte, tall bool) [40]Sprite {
+	var sprites [40]Sprite
+	for n := range sprites {
+		s := oam[4*n : 4*n+4]
+		sprites[n] = Sprite{Y: s[0], X: s[1], Tile: s[2], Flags: s[3]}
+		if tall {
+			sprites[n].Tile &= 0xFE
+		}
+	}
+	return sprites
+}
+
+// Palette returns which of the sprite palettes it's drawn with, 0 for OBP0 or 1 for OBP1.
+func (s Sprite) Palette() int {
+	return int(s.Flags >> 4 & 1)
+}
+
+// Row formats the sprite, the nth in OAM, as a row of a table: its number, position, tile number and attributes.
+func (s Sprite) Row(n int) string {
+	return fmt.Sprintf("%-2d %3d %3d  %02X  %s", n, s.Y, s.X, s.Tile, s.flags())
+}
+
+// The attributes of a sprite: behind the background, flipped vertically and horizontally, and its palette.
+func (s Sprite) flags() string {
+	f := []byte("--- P0")
+	if s.Flags&0x80 != 0 {
+		f[0] = 'B'
+	}
+	if s.Flags&0x40 != 0 {
+		f[1] = 'Y'
+	}
+	if s.Flags&0x20 != 0 {
+		f[2] = 'X'
+	}
+	if s.Flags&0x10 != 0 {
+		f[5] = '1'
+	}
+	return string(f)
+}
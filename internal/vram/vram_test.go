@@ -0,0 +1,121 @@
+package vram
+
+import (
+	"image"
+	"testing"
+)
+
+func TestShades(t *testing.T) {
+	tests := []struct {
+		palette byte
+		want    [4]byte
+	}{
+		{0xE4, [4]byte{0, 1, 2, 3}},
+		{0x1B, [4]byte{3, 2, 1, 0}},
+		{0xFC, [4]byte{0, 3, 3, 3}},
+		{0x00, [4]byte{0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		if got := Shades(test.palette); got != test.want {
+			t.Errorf("Shades(%02X) = %v, want %v", test.palette, got, test.want)
+		}
+	}
+}
+
+func TestTile(t *testing.T) {
+	vram := make([]byte, 0x20)
+	// The second tile: a first row with the 4 color numbers twice, a last one all 3, and 0 in between.
+	copy(vram[0x10:], []byte{0x55, 0x33})
+	vram[0x1E], vram[0x1F] = 0xFF, 0xFF
+	tile := Tile(vram, 0x10, NoPalette)
+	if want := [8]byte{0, 1, 2, 3, 0, 1, 2, 3}; tile[0] != want {
+		t.Errorf("got first row %v, want %v", tile[0], want)
+	}
+	if tile[3] != [8]byte{} || tile[7] != [8]byte{3, 3, 3, 3, 3, 3, 3, 3} {
+		t.Errorf("got rows %v and %v", tile[3], tile[7])
+	}
+	// With a palette, each color number is drawn with its shade.
+	tile = Tile(vram, 0x10, Shades(0x1B))
+	if want := [8]byte{3, 2, 1, 0, 3, 2, 1, 0}; tile[0] != want {
+		t.Errorf("got first row %v with a palette, want %v", tile[0], want)
+	}
+}
+
+func TestMapTileOffset(t *testing.T) {
+	tests := []struct {
+		number, lcdc byte
+		want         int
+	}{
+		{0x00, 0x91, 0x0000},
+		{0x01, 0x91, 0x0010},
+		{0xFF, 0x91, 0x0FF0},
+		{0x00, 0x81, 0x1000},
+		{0x7F, 0x81, 0x17F0},
+		{0x80, 0x81, 0x0800},
+		{0xFF, 0x81, 0x0FF0},
+	}
+	for _, test := range tests {
+		if got := MapTileOffset(test.number, test.lcdc); got != test.want {
+			t.Errorf("MapTileOffset(%02X, %02X) = %04X, want %04X", test.number, test.lcdc, got, test.want)
+		}
+	}
+}
+
+func TestViewport(t *testing.T) {
+	tests := []struct {
+		scx, scy int
+		corners  [4]image.Point
+	}{
+		{0, 0, [4]image.Point{{0, 0}, {159, 0}, {0, 143}, {159, 143}}},
+		{16, 8, [4]image.Point{{16, 8}, {175, 8}, {16, 151}, {175, 151}}},
+		// Past the edges, it wraps around.
+		{200, 180, [4]image.Point{{200, 180}, {103, 180}, {200, 67}, {103, 67}}},
+	}
+	for _, test := range tests {
+		points := make(map[image.Point]bool)
+		for _, p := range Viewport(test.scx, test.scy) {
+			if p.X < 0 || p.X > 255 || p.Y < 0 || p.Y > 255 {
+				t.Errorf("SCX %d SCY %d: %v is outside the background", test.scx, test.scy, p)
+			}
+			points[p] = true
+		}
+		for _, corner := range test.corners {
+			if !points[corner] {
+				t.Errorf("SCX %d SCY %d: corner %v missing", test.scx, test.scy, corner)
+			}
+		}
+		// The inside isn't drawn.
+		if inside := image.Pt((test.scx+80)%256, (test.scy+72)%256); points[inside] {
+			t.Errorf("SCX %d SCY %d: %v is drawn", test.scx, test.scy, inside)
+		}
+		if len(points) != 2*160+2*142 {
+			t.Errorf("SCX %d SCY %d: got %d points", test.scx, test.scy, len(points))
+		}
+	}
+}
+
+func TestSprites(t *testing.T) {
+	oam := make([]byte, 0xA0)
+	copy(oam, []byte{0x10, 0x08, 0x2B, 0x00})
+	copy(oam[0x9C:], []byte{0x98, 0xA8, 0x05, 0xF0})
+	tests := []struct {
+		tall  bool
+		first string
+		last  string
+	}{
+		{false, "0   16   8  2B  --- P0", "39 152 168  05  BYX P1"},
+		{true, "0   16   8  2A  --- P0", "39 152 168  04  BYX P1"},
+	}
+	for _, test := range tests {
+		sprites := Sprites(oam, test.tall)
+		if first := sprites[0].Row(0); first != test.first {
+			t.Errorf("tall %t: got first row %q, want %q", test.tall, first, test.first)
+		}
+		if last := sprites[39].Row(39); last != test.last {
+			t.Errorf("tall %t: got last row %q, want %q", test.tall, last, test.last)
+		}
+		if sprites[0].Palette() != 0 || sprites[39].Palette() != 1 {
+			t.Errorf("tall %t: got palettes %d and %d", test.tall, sprites[0].Palette(), sprites[39].Palette())
+		}
+	}
+}